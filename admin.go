@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requireAdmin only lets through requests with the admin token as bearer token.
+func requireAdmin(c *gin.Context) {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	if config.AdminToken == "" || subtle.ConstantTimeCompare([]byte(token), []byte(config.AdminToken)) != 1 {
+		c.AbortWithStatus(http.StatusUnauthorized)
+		return
+	}
+	c.Next()
+}
+
+type warmRequest struct {
+	Ids []string `json:"ids" binding:"required"`
+}
+
+type warmResult struct {
+	Id    string `json:"id"`
+	Error string `json:"error,omitempty"`
+}
+
+// warmCache fetches the embed data of the given manga ids to fill the cache,
+// with at most `workers` manga fetched at the same time.
+func warmCache(ctx context.Context, ids []string, workers int) []warmResult {
+	if workers < 1 {
+		workers = 1
+	}
+
+	results := make([]warmResult, len(ids))
+	jobs := make(chan int)
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = warmResult{Id: ids[i]}
+
+				val, err := dexClient.RequestJSON(ctx, mangaEndpoint, ids[i])
+				if err == nil {
+					_, err = parseMangaResponse(ctx, val, ids[i], nil)
+				}
+				if err != nil {
+					results[i].Error = err.Error()
+				}
+			}
+		}()
+	}
+
+	for i := range ids {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	return results
+}
+
+// warm fills the cache for a batch of manga ids.
+func warm(c *gin.Context) {
+	var req warmRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": warmCache(c.Request.Context(), req.Ids, config.WarmConcurrency),
+	})
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWarmCacheConcurrency(t *testing.T) {
+	_, fixtures := newTestService(t, nil)
+
+	var mu sync.Mutex
+	running, most := 0, 0
+	var ids []string
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+		ids = append(ids, id)
+		fixtures.handle("/manga/"+id, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			mu.Unlock()
+
+			time.Sleep(10 * time.Millisecond)
+			fmt.Fprintf(w, `{"result": "ok", "data": {"id": %q, "type": "manga", "attributes": {"title": {"en": "Warm"}}, "relationships": []}}`, id)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+	}
+	ids = append(ids, "0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d")
+
+	results := warmCache(context.Background(), ids, 3)
+	if most > 3 {
+		t.Errorf("%d manga were fetched at the same time, want at most 3", most)
+	}
+	if len(results) != len(ids) {
+		t.Fatalf("%d results, want %d", len(results), len(ids))
+	}
+	for i, res := range results[:8] {
+		if res.Id != ids[i] || res.Error != "" {
+			t.Errorf("result %d = %+v, want %s without an error", i, res, ids[i])
+		}
+	}
+	if res := results[8]; res.Error == "" {
+		t.Errorf("result of a missing manga = %+v, want an error", res)
+	}
+}
+
+func TestWarmRequiresAdmin(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.AdminToken = "secret"
+		c.CacheTTL = time.Minute
+	})
+
+	post := func(token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/warm", strings.NewReader(`{"ids": ["7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"]}`))
+		req.Header.Set("Content-Type", "application/json")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	for _, token := range []string{"", "wrong"} {
+		if w := post(token); w.Code != http.StatusUnauthorized {
+			t.Errorf("status with token %q = %d, want 401", token, w.Code)
+		}
+	}
+
+	w := post("secret")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(), `{"results":[{"id":"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"}]}`)
+
+	// The embed is served from the warmed cache
+	get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); n != 1 {
+		t.Errorf("manga requested %d times, want 1", n)
+	}
+}
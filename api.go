@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// apiEmbed returns the embed data of the manga as JSON.
+func apiEmbed(c *gin.Context) {
+	comicMeta, status := resolveEmbed(c, c.Param("md-id"))
+	if comicMeta == nil {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	if fields := c.Query("fields"); fields != "" {
+		var err error
+		if comicMeta, err = selectFields(comicMeta, fields); err != nil {
+			c.String(http.StatusBadRequest, "invalid request: %v", err)
+			return
+		}
+	}
+
+	etag := setETag(c, comicMeta, "json")
+	modified := setLastModified(c, comicMeta)
+	if status == http.StatusOK && notModified(c, etag, modified) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	c.JSON(status, comicMeta)
+}
+
+// selectFields returns only the comma separated fields of the embed data.
+// A field the data does not have is an error, so typos are not mistaken for
+// missing data.
+func selectFields(data gin.H, fields string) (gin.H, error) {
+	selected := gin.H{}
+	for _, field := range strings.Split(fields, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		value, ok := data[field]
+		if !ok {
+			return nil, fmt.Errorf("unknown field %q", field)
+		}
+		selected[field] = value
+	}
+	return selected, nil
+}
+
+// titleText returns only the title of the manga as plain text.
+func titleText(c *gin.Context) {
+	comicMeta, status := resolveEmbed(c, c.Param("md-id"))
+	if comicMeta == nil {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	title, _ := comicMeta["title"].(string)
+	if title == "" {
+		title, _ = comicMeta["og_title"].(string)
+	}
+	c.String(status, title)
+}
+
+// embedHash returns a hash of the embed data, which is the same
+// for every representation of the data.
+func embedHash(data gin.H) string {
+	// Map keys are sorted when marshalled, so equal data gives equal bytes
+	b, err := json.Marshal(data)
+	if err != nil {
+		return ""
+	}
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:8])
+}
+
+// setETag sets and returns an ETag of the embed data hash and the response format.
+func setETag(c *gin.Context, data gin.H, format string) string {
+	hash := embedHash(data)
+	if hash == "" {
+		return ""
+	}
+
+	etag := fmt.Sprintf(`"%s-%s"`, hash, format)
+	c.Header("ETag", etag)
+	return etag
+}
+
+// setLastModified sets the Last-Modified header to when the manga was last
+// updated on MangaDex and returns that time, which is zero if unknown.
+func setLastModified(c *gin.Context, data gin.H) time.Time {
+	updated, _ := data["updated_at"].(time.Time)
+	if updated.IsZero() {
+		return time.Time{}
+	}
+
+	c.Header("Last-Modified", updated.UTC().Format(http.TimeFormat))
+	return updated
+}
+
+// notModified reports whether the If-None-Match header of the request matches
+// etag or, without If-None-Match, whether If-Modified-Since is not before
+// modified.
+func notModified(c *gin.Context, etag string, modified time.Time) bool {
+	if inm := c.GetHeader("If-None-Match"); inm != "" {
+		if etag == "" {
+			return false
+		}
+
+		for _, match := range strings.Split(inm, ",") {
+			match = strings.TrimPrefix(strings.TrimSpace(match), "W/")
+			if match == etag || match == "*" {
+				return true
+			}
+		}
+		return false
+	}
+
+	// The header has a precision of seconds
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	return err == nil && !modified.IsZero() && !modified.Truncate(time.Second).After(since)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestETagSharedByFormats(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	const id = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	html := get(r, "/title/"+id).Header().Get("ETag")
+	json := get(r, "/api/title/"+id).Header().Get("ETag")
+	if html == "" || json == "" {
+		t.Fatalf("ETags = %q and %q, want both set", html, json)
+	}
+
+	if !strings.HasSuffix(html, `-html"`) || !strings.HasSuffix(json, `-json"`) {
+		t.Errorf("ETags %q and %q do not name their format", html, json)
+	}
+	if strings.TrimSuffix(html, `-html"`) != strings.TrimSuffix(json, `-json"`) {
+		t.Errorf("ETags %q and %q have a different hash", html, json)
+	}
+
+	// The same data gives the same ETag again, other data another one
+	if again := get(r, "/title/"+id).Header().Get("ETag"); again != html {
+		t.Errorf("ETag changed from %q to %q", html, again)
+	}
+	if other := get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f").Header().Get("ETag"); other == html {
+		t.Errorf("ETag of another manga is also %q", html)
+	}
+
+	if w := get(r, "/title/"+id, "If-None-Match", html); w.Code != http.StatusNotModified {
+		t.Errorf("status with a matching If-None-Match = %d, want 304", w.Code)
+	}
+	if w := get(r, "/title/"+id, "If-None-Match", json); w.Code != http.StatusOK {
+		t.Errorf("status with the ETag of the JSON = %d, want 200", w.Code)
+	}
+}
+
+func TestTitleText(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	const id = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	tests := []struct {
+		path    string
+		headers []string
+		want    string
+	}{
+		{"/title/" + id + "/name.txt", nil, "Yotsuba&!"},
+		{"/title/" + id + "?format=txt", nil, "Yotsuba&!"},
+		{"/title/" + id + "/name.txt?lang=ja", nil, "よつばと！"},
+		{"/title/" + id + "/name.txt", []string{"Accept-Language", "ja-ro, en;q=0.5"}, "Yotsubato!"},
+		{"/title/" + id, []string{"Accept", "text/plain"}, "Yotsuba&!"},
+	}
+
+	for _, tt := range tests {
+		w := get(r, tt.path, tt.headers...)
+		if w.Code != http.StatusOK {
+			t.Errorf("status of %s = %d, want 200", tt.path, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+			t.Errorf("Content-Type of %s = %q, want text/plain", tt.path, ct)
+		}
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("title of %s %v = %q, want %q", tt.path, tt.headers, got, tt.want)
+		}
+	}
+
+	if w := get(r, "/title/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d/name.txt"); w.Code != http.StatusNotFound {
+		t.Errorf("status of a missing manga = %d, want 404", w.Code)
+	}
+}
+
+func TestApiNotModified(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const id = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	first := get(r, "/api/title/"+id)
+	etag := first.Header().Get("ETag")
+
+	w := get(r, "/api/title/"+id, "If-None-Match", etag)
+	if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+		t.Errorf("matching If-None-Match = %d with %d bytes, want 304 without a body", w.Code, w.Body.Len())
+	}
+	if w := get(r, "/api/title/"+id, "If-None-Match", `"other-json", W/`+etag); w.Code != http.StatusNotModified {
+		t.Errorf("weak match in a list = %d, want 304", w.Code)
+	}
+	if w := get(r, "/api/title/"+id, "If-None-Match", "*"); w.Code != http.StatusNotModified {
+		t.Errorf("If-None-Match * = %d, want 304", w.Code)
+	}
+
+	// Once the manga changes the old ETag no longer matches
+	body, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", id+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures.handle("/manga/"+id, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Replace(body, []byte(`"status": "ongoing"`), []byte(`"status": "completed"`), 1))
+	})
+
+	w = get(r, "/api/title/"+id, "If-None-Match", etag)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status after the manga changed = %d, want 200", w.Code)
+	}
+	if changed := w.Header().Get("ETag"); changed == etag {
+		t.Errorf("ETag is still %s after the manga changed", etag)
+	}
+	assertContains(t, w.Body.String(), `"status":"Completed"`)
+}
+
+func TestEmbedAcceptFormats(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	const path = "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	tests := []struct {
+		accept      string
+		contentType string
+		body        string
+	}{
+		{"", "text/html", `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html", `property="og:title"`},
+		{"*/*", "text/html", `property="og:title"`},
+		{"application/json", "application/json", `"og_title":"Yotsuba\u0026! - Azuma Kiyohiko"`},
+		{"application/json, text/html;q=0.5", "application/json", `"og_title"`},
+		{"text/plain", "text/plain", "Yotsuba&!"},
+		{"image/png", "text/html", `property="og:title"`},
+	}
+
+	for _, tt := range tests {
+		w := get(r, path, "Accept", tt.accept)
+		if w.Code != http.StatusOK {
+			t.Errorf("status with Accept %q = %d, want 200", tt.accept, w.Code)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, tt.contentType) {
+			t.Errorf("Content-Type with Accept %q = %q, want %s", tt.accept, ct, tt.contentType)
+		}
+		if vary := w.Header().Get("Vary"); !strings.Contains(vary, "Accept") {
+			t.Errorf("Vary with Accept %q = %q, want Accept", tt.accept, vary)
+		}
+		assertContains(t, w.Body.String(), tt.body)
+	}
+
+	// Every format has the status of the embed
+	for _, accept := range []string{"text/html", "application/json", "text/plain"} {
+		if w := get(r, "/title/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d", "Accept", accept); w.Code != http.StatusNotFound {
+			t.Errorf("status of a missing manga with Accept %q = %d, want 404", accept, w.Code)
+		}
+	}
+}
+
+func TestSelectFields(t *testing.T) {
+	data := gin.H{"title": "Yotsuba&!", "og_image": "cover.jpg", "year": "2003"}
+
+	tests := []struct {
+		fields string
+		want   gin.H
+		err    bool
+	}{
+		{"title", gin.H{"title": "Yotsuba&!"}, false},
+		{"title,og_image", gin.H{"title": "Yotsuba&!", "og_image": "cover.jpg"}, false},
+		{" title , year ,", gin.H{"title": "Yotsuba&!", "year": "2003"}, false},
+		{"title,title", gin.H{"title": "Yotsuba&!"}, false},
+		{",", gin.H{}, false},
+		{"title,cover", nil, true},
+		{"Title", nil, true},
+	}
+
+	for _, tt := range tests {
+		got, err := selectFields(data, tt.fields)
+		if (err != nil) != tt.err {
+			t.Errorf("selectFields(%q) error = %v, want error %v", tt.fields, err, tt.err)
+			continue
+		}
+		if !tt.err && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectFields(%q) = %v, want %v", tt.fields, got, tt.want)
+		}
+	}
+}
+
+func TestApiFields(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	const path = "/api/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	tests := []struct {
+		query  string
+		status int
+		want   string
+	}{
+		{"?fields=title", http.StatusOK, `{"title":"Yotsuba\u0026!"}`},
+		{"?fields=title,year", http.StatusOK, `{"title":"Yotsuba\u0026!","year":"2003"}`},
+		{"?fields=title,nope", http.StatusBadRequest, `invalid request: unknown field "nope"`},
+	}
+
+	for _, tt := range tests {
+		w := get(r, path+tt.query)
+		if w.Code != tt.status {
+			t.Errorf("status of %s = %d, want %d", tt.query, w.Code, tt.status)
+		}
+		if got := w.Body.String(); got != tt.want {
+			t.Errorf("body of %s = %s, want %s", tt.query, got, tt.want)
+		}
+	}
+
+	// Every field without a selection, and the selection has its own ETag
+	all := get(r, path)
+	assertContains(t, all.Body.String(), `"title":`, `"og_image":`, `"authors":`)
+	if selected := get(r, path+"?fields=title"); selected.Header().Get("ETag") == all.Header().Get("ETag") {
+		t.Errorf("selected fields have the ETag of all fields %q", all.Header().Get("ETag"))
+	}
+}
+
+func TestLastModified(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const id = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+	const updated = "Sat, 05 Mar 2022 18:30:00 GMT"
+
+	tests := []struct {
+		headers []string
+		status  int
+	}{
+		{nil, http.StatusOK},
+		{[]string{"If-Modified-Since", updated}, http.StatusNotModified},
+		{[]string{"If-Modified-Since", "Sun, 06 Mar 2022 00:00:00 GMT"}, http.StatusNotModified},
+		{[]string{"If-Modified-Since", "Sat, 05 Mar 2022 18:29:59 GMT"}, http.StatusOK},
+		{[]string{"If-Modified-Since", "yesterday"}, http.StatusOK},
+		// If-None-Match takes precedence
+		{[]string{"If-Modified-Since", updated, "If-None-Match", `"other"`}, http.StatusOK},
+	}
+
+	for _, path := range []string{"/title/" + id, "/api/title/" + id} {
+		for _, tt := range tests {
+			w := get(r, path, tt.headers...)
+			if w.Code != tt.status {
+				t.Errorf("status of %s with %q = %d, want %d", path, tt.headers, w.Code, tt.status)
+			}
+			if got := w.Header().Get("Last-Modified"); got != updated {
+				t.Errorf("Last-Modified of %s with %q = %q, want %q", path, tt.headers, got, updated)
+			}
+		}
+	}
+
+	// Without updatedAt there is nothing to compare
+	body, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", id+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures.handle("/manga/"+id, func(w http.ResponseWriter, r *http.Request) {
+		w.Write(bytes.Replace(body, []byte(`"updatedAt": "2022-03-05T18:30:00+00:00",`), nil, 1))
+	})
+	w := get(r, "/title/"+id, "If-Modified-Since", updated)
+	if w.Code != http.StatusOK {
+		t.Errorf("status without updatedAt = %d, want 200", w.Code)
+	}
+	if got := w.Header().Get("Last-Modified"); got != "" {
+		t.Errorf("Last-Modified without updatedAt = %q, want none", got)
+	}
+
+	// Missing manga are never not modified
+	if w := get(r, "/title/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d", "If-Modified-Since", updated); w.Code != http.StatusNotFound {
+		t.Errorf("status of a missing manga = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Errors of RequestJSON are one of the types below, so handlers can map them
+// to a status with errorStatus instead of inspecting messages.
+
+// ErrOverloaded is returned when too many requests are already waiting
+// on the rate limiter.
+var ErrOverloaded = errors.New("too many requests waiting on the rate limiter")
+
+// ErrCallBudget is returned when the request that needs the response already
+// made MaxUpstreamCalls requests to MangaDex.
+var ErrCallBudget = errors.New("too many MangaDex requests for one request")
+
+// ErrMalformed is returned for an url that recently gave a response that
+// could not be parsed.
+var ErrMalformed = errors.New("malformed response")
+
+// LimiterError is returned when a request is not sent because of our own
+// rate limiter or circuit breaker, as opposed to a failed request to MangaDex.
+type LimiterError struct {
+	Err error
+}
+
+func (e *LimiterError) Error() string {
+	return fmt.Sprintf("rate limiter: %v", e.Err)
+}
+
+func (e *LimiterError) Unwrap() error {
+	return e.Err
+}
+
+// NotFoundError is returned when MangaDex responds with 404 or 410.
+type NotFoundError struct {
+	Url  string
+	Code int
+}
+
+func (e *NotFoundError) Error() string {
+	return fmt.Sprintf("not found: %s: status %d", e.Url, e.Code)
+}
+
+// UpstreamError is returned when a request to MangaDex fails, either with an
+// unexpected status or, if Code is 0, without a response.
+type UpstreamError struct {
+	Url  string
+	Code int
+	Err  error
+}
+
+func (e *UpstreamError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("could not complete manga request: %s: status %d", e.Url, e.Code)
+	}
+	return fmt.Sprintf("could not complete manga request: %v", e.Err)
+}
+
+func (e *UpstreamError) Unwrap() error {
+	return e.Err
+}
+
+// ParseError is returned when the response of MangaDex is not valid JSON.
+type ParseError struct {
+	Url string
+	Err error
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("could not unmarshal response of %s: %v", e.Url, e.Err)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// errorStatus maps an error of a MangaDex request to the status to respond with.
+func errorStatus(err error) int {
+	var limiterErr *LimiterError
+	var notFoundErr *NotFoundError
+	var upstreamErr *UpstreamError
+	var parseErr *ParseError
+
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.As(err, &limiterErr):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case errors.As(err, &notFoundErr):
+		return http.StatusNotFound
+	case errors.As(err, &upstreamErr), errors.As(err, &parseErr):
+		return http.StatusBadGateway
+	default:
+		return http.StatusInternalServerError
+	}
+}
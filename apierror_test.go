@@ -0,0 +1,63 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{nil, http.StatusOK},
+		{&LimiterError{Err: ErrOverloaded}, http.StatusServiceUnavailable},
+		{&LimiterError{Err: ErrBreakerOpen}, http.StatusServiceUnavailable},
+		{&NotFoundError{Url: "/manga/x", Code: http.StatusNotFound}, http.StatusNotFound},
+		{&NotFoundError{Url: "/manga/x", Code: http.StatusGone}, http.StatusNotFound},
+		{&UpstreamError{Url: "/manga/x", Code: http.StatusInternalServerError}, http.StatusBadGateway},
+		{&UpstreamError{Url: "/manga/x", Err: errors.New("connection reset")}, http.StatusBadGateway},
+		{&UpstreamError{Url: "/manga/x", Err: context.DeadlineExceeded}, http.StatusGatewayTimeout},
+		{&ParseError{Url: "/manga/x", Err: ErrMalformed}, http.StatusBadGateway},
+		{fmt.Errorf("could not complete manga request: %w", context.DeadlineExceeded), http.StatusGatewayTimeout},
+		{fmt.Errorf("author: %w", &NotFoundError{Url: "/author/x"}), http.StatusNotFound},
+		{errors.New("template failed"), http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		if got := errorStatus(tt.err); got != tt.want {
+			t.Errorf("errorStatus(%v) = %d, want %d", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedErrorStatus(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"upstream error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusBadGateway},
+		{"malformed response", func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(`<html>`))
+		}, http.StatusBadGateway},
+		{"missing manga", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+		}, http.StatusNotFound},
+	}
+
+	for i, tt := range tests {
+		id := fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+		fixtures.handle("/manga/"+id, tt.handler)
+		if w := get(r, "/title/"+id); w.Code != tt.want {
+			t.Errorf("status with a %s = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
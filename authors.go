@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// AuthorFormat describes how the authors are added to the title.
+type AuthorFormat struct {
+	// Between the title and the authors
+	Separator string
+	// Between two authors
+	Joiner string
+	// Put "by" before the authors
+	By bool
+	// Maximum number of authors shown, 0 shows all
+	Max int
+}
+
+// formatAuthors appends the names of the authors to the title,
+// e.g. "Title - by Author, Other Author".
+func formatAuthors(title string, authors []string, f AuthorFormat) string {
+	var names []string
+	for _, name := range authors {
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+
+	if len(names) == 0 {
+		return title
+	}
+	if f.Max > 0 && len(names) > f.Max {
+		names = names[:f.Max]
+	}
+
+	byline := strings.Join(names, f.Joiner)
+	if f.By {
+		byline = "by " + byline
+	}
+	return title + f.Separator + byline
+}
+
+// creditLine returns "Story by Author, Art by Artist" if the story and art
+// are not by the same people, or an empty string if they are or either is
+// unknown.
+func creditLine(authors []string, artists []string) string {
+	if len(authors) == 0 || len(artists) == 0 || sameNames(authors, artists) {
+		return ""
+	}
+	return fmt.Sprintf("Story by %s, Art by %s", strings.Join(authors, " & "), strings.Join(artists, " & "))
+}
+
+// sameNames reports whether a and b contain the same names in any order.
+func sameNames(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	a, b = append([]string{}, a...), append([]string{}, b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
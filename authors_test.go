@@ -0,0 +1,72 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestFormatAuthors(t *testing.T) {
+	plain := AuthorFormat{Separator: " - ", Joiner: ", "}
+	by := AuthorFormat{Separator: " ", Joiner: " & ", By: true}
+	capped := AuthorFormat{Separator: " - ", Joiner: ", ", Max: 2}
+
+	tests := []struct {
+		format  AuthorFormat
+		authors []string
+		want    string
+	}{
+		{plain, nil, "Death Note"},
+		{plain, []string{""}, "Death Note"},
+		{plain, []string{"Ohba Tsugumi"}, "Death Note - Ohba Tsugumi"},
+		{plain, []string{"Ohba Tsugumi", "", "Obata Takeshi"}, "Death Note - Ohba Tsugumi, Obata Takeshi"},
+		{by, []string{"Ohba Tsugumi"}, "Death Note by Ohba Tsugumi"},
+		{by, []string{"Ohba Tsugumi", "Obata Takeshi"}, "Death Note by Ohba Tsugumi & Obata Takeshi"},
+		{capped, []string{"Ohba Tsugumi", "Obata Takeshi"}, "Death Note - Ohba Tsugumi, Obata Takeshi"},
+		{capped, []string{"", "Ohba Tsugumi", "Obata Takeshi", "Third"}, "Death Note - Ohba Tsugumi, Obata Takeshi"},
+	}
+
+	for _, tt := range tests {
+		if got := formatAuthors("Death Note", tt.authors, tt.format); got != tt.want {
+			t.Errorf("formatAuthors(%q, %+v) = %q, want %q", tt.authors, tt.format, got, tt.want)
+		}
+	}
+}
+
+func TestCreditLine(t *testing.T) {
+	tests := []struct {
+		authors []string
+		artists []string
+		want    string
+	}{
+		{[]string{"Azuma Kiyohiko"}, []string{"Azuma Kiyohiko"}, ""},
+		{[]string{"Ohba Tsugumi"}, []string{"Obata Takeshi"}, "Story by Ohba Tsugumi, Art by Obata Takeshi"},
+		{[]string{"Ohba Tsugumi", "Obata Takeshi"}, []string{"Obata Takeshi"}, "Story by Ohba Tsugumi & Obata Takeshi, Art by Obata Takeshi"},
+		{[]string{"A", "B"}, []string{"B", "A"}, ""},
+		{[]string{"A", "A"}, []string{"A"}, "Story by A & A, Art by A"},
+		{[]string{"Ohba Tsugumi"}, nil, ""},
+		{nil, []string{"Obata Takeshi"}, ""},
+		{nil, nil, ""},
+	}
+
+	for _, tt := range tests {
+		if got := creditLine(tt.authors, tt.artists); got != tt.want {
+			t.Errorf("creditLine(%q, %q) = %q, want %q", tt.authors, tt.artists, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedCredit(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	// Azuma Kiyohiko is both the author and the artist of Yotsuba&!
+	w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if strings.Contains(w.Body.String(), "Story by") {
+		t.Errorf("embed credits the same author and artist separately:\n%s", w.Body.String())
+	}
+	assertContains(t, get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "Accept", "application/json").Body.String(), `"credit":""`)
+
+	w = get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	assertContains(t, w.Body.String(), `&#10;&#10;Story by Ohba Tsugumi &amp; Obata Takeshi, Art by Obata Takeshi&#10;&#10;A notebook`)
+	assertContains(t, get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f", "Accept", "application/json").Body.String(),
+		`"credit":"Story by Ohba Tsugumi \u0026 Obata Takeshi, Art by Obata Takeshi"`)
+}
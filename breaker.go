@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrBreakerOpen is returned instead of requesting an endpoint that failed
+// too often in a row.
+var ErrBreakerOpen = errors.New("circuit breaker open")
+
+// breaker stops requests to an endpoint after threshold consecutive failures,
+// until cooldown has passed. A nil breaker never opens.
+type breaker struct {
+	mu        sync.Mutex
+	clock     Clock
+	threshold int
+	cooldown  time.Duration
+
+	failures int
+	openedAt time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration, clock Clock) *breaker {
+	if threshold <= 0 {
+		return nil
+	}
+	return &breaker{clock: clock, threshold: threshold, cooldown: cooldown}
+}
+
+// open reports whether requests are currently stopped. Once the cooldown has
+// passed requests are let through again, and the next failure reopens it.
+func (b *breaker) open() bool {
+	if b == nil {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.failures >= b.threshold && b.clock.Now().Before(b.openedAt.Add(b.cooldown))
+}
+
+// record updates the breaker with the outcome of a request. Requests our own
+// rate limiter did not send say nothing about MangaDex and are not counted.
+func (b *breaker) record(err error) {
+	var limiterErr *LimiterError
+	if b == nil || errors.As(err, &limiterErr) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isFailure(err) {
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	if b.failures >= b.threshold {
+		b.openedAt = b.clock.Now()
+	}
+}
+
+// breakerSet holds a breaker for every API base url and endpoint, so a failing
+// mirror does not stop requests to the others. Breakers are created on first
+// use, endpoints not in the set have none.
+type breakerSet struct {
+	mu        sync.Mutex
+	clock     Clock
+	threshold int
+	cooldown  time.Duration
+	endpoints map[string]bool
+	breakers  map[string]*breaker
+}
+
+func newBreakerSet(endpoints []string, threshold int, cooldown time.Duration, clock Clock) *breakerSet {
+	s := &breakerSet{
+		clock:     clock,
+		threshold: threshold,
+		cooldown:  cooldown,
+		endpoints: make(map[string]bool),
+		breakers:  make(map[string]*breaker),
+	}
+	for _, endpoint := range endpoints {
+		s.endpoints[endpoint] = true
+	}
+	return s
+}
+
+// get returns the breaker of the endpoint at the base url, nil if the
+// endpoint has none.
+func (s *breakerSet) get(base string, endpoint string) *breaker {
+	if s == nil || !s.endpoints[endpoint] {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := base + endpoint
+	b, ok := s.breakers[key]
+	if !ok {
+		b = newBreaker(s.threshold, s.cooldown, s.clock)
+		s.breakers[key] = b
+	}
+	return b
+}
+
+// openEndpoints returns the endpoints whose breaker is open at all of the
+// base urls, sorted.
+func (s *breakerSet) openEndpoints(bases []string) []string {
+	if s == nil {
+		return nil
+	}
+
+	var open []string
+	for endpoint := range s.endpoints {
+		closed := false
+		for _, base := range bases {
+			if !s.get(base, endpoint).open() {
+				closed = true
+				break
+			}
+		}
+		if !closed {
+			open = append(open, endpoint)
+		}
+	}
+	sort.Strings(open)
+	return open
+}
+
+// isFailure reports whether err means MangaDex is unavailable, as opposed to
+// a missing resource or a request given up by us.
+func isFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var upstreamErr *UpstreamError
+	if !errors.As(err, &upstreamErr) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if upstreamErr.Code == 0 {
+		return true
+	}
+	return upstreamErr.Code >= http.StatusInternalServerError || upstreamErr.Code == http.StatusTooManyRequests
+}
+
+// ready reports whether the instance can serve embeds, which is not the case
+// while the circuit breaker of a MangaDex endpoint is open for the API and
+// all of its mirrors.
+func ready(c *gin.Context) {
+	var open []string
+	for _, endpoint := range dexClient.breakers.openEndpoints(dexClient.bases()) {
+		open = append(open, strings.SplitN(endpoint, "/", 3)[1])
+	}
+
+	if len(open) > 0 {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"ready":  false,
+			"reason": fmt.Sprintf("circuit breaker open for %s", strings.Join(open, ", ")),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"ready": true})
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestIsFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&UpstreamError{Code: http.StatusInternalServerError}, true},
+		{&UpstreamError{Code: http.StatusBadGateway}, true},
+		{&UpstreamError{Code: http.StatusTooManyRequests}, true},
+		{&UpstreamError{Err: errors.New("connection refused")}, true},
+		{&UpstreamError{Code: http.StatusNotFound}, false},
+		{&UpstreamError{Err: context.Canceled}, false},
+		{fmt.Errorf("manga: %w", &UpstreamError{Code: http.StatusServiceUnavailable}), true},
+		{errors.New("unexpected end of JSON input"), false},
+	}
+
+	for _, tt := range tests {
+		if got := isFailure(tt.err); got != tt.want {
+			t.Errorf("isFailure(%v) = %t, want %t", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestBreaker(t *testing.T) {
+	clock := newFakeClock()
+	b := newBreaker(2, time.Minute, clock)
+	failure := &UpstreamError{Code: http.StatusInternalServerError}
+
+	b.record(failure)
+	b.record(&UpstreamError{Code: http.StatusNotFound})
+	b.record(failure)
+	if b.open() {
+		t.Fatal("breaker opened after failures with a success in between")
+	}
+
+	b.record(failure)
+	b.record(&LimiterError{Err: ErrOverloaded})
+	if !b.open() {
+		t.Fatal("breaker not open after 2 failures in a row")
+	}
+
+	clock.Advance(time.Minute)
+	if b.open() {
+		t.Fatal("breaker still open after the cooldown")
+	}
+	b.record(failure)
+	if !b.open() {
+		t.Fatal("breaker not reopened by a failure after the cooldown")
+	}
+
+	if newBreaker(0, time.Minute, clock).open() {
+		t.Error("disabled breaker is open")
+	}
+}
+
+func TestReady(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.ApiMirrors = []string{"http://mirror.example.com"}
+	})
+	clock := newFakeClock()
+	dexClient.breakers = newBreakerSet([]string{mangaEndpoint, coverEndpoint}, 1, time.Minute, clock)
+	failure := &UpstreamError{Code: http.StatusServiceUnavailable}
+
+	if w := get(r, "/ready"); w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	// A mirror can still serve the manga
+	dexClient.breakers.get(config.ApiUrl, mangaEndpoint).record(failure)
+	if w := get(r, "/ready"); w.Code != http.StatusOK {
+		t.Errorf("status with a mirror left = %d, want 200", w.Code)
+	}
+
+	dexClient.breakers.get("http://mirror.example.com", mangaEndpoint).record(failure)
+	w := get(r, "/ready")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status with the breaker open = %d, want 503", w.Code)
+	}
+	assertContains(t, w.Body.String(), `"ready":false`, `"reason":"circuit breaker open for manga"`)
+
+	clock.Advance(time.Minute)
+	if w := get(r, "/ready"); w.Code != http.StatusOK {
+		t.Errorf("status after the cooldown = %d, want 200", w.Code)
+	}
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type cacheEntry struct {
+	Body    []byte    `json:"body"`
+	Expires time.Time `json:"expires"`
+}
+
+// Cache is a concurrency safe in-memory cache of response bodies.
+type Cache struct {
+	mu         sync.Mutex
+	entries    map[string]cacheEntry
+	ttl        time.Duration
+	maxEntries int
+	clock      Clock
+	// Fraction of the ttl by which entries expire earlier or later at random,
+	// so entries cached together are not all fetched again at once
+	jitter float64
+
+	// Lookups that found an entry and that did not, read with Stats
+	hits   int64
+	misses int64
+}
+
+func newCache(ttl time.Duration, maxEntries int, clock Clock) *Cache {
+	return &Cache{
+		entries:    map[string]cacheEntry{},
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		clock:      clock,
+	}
+}
+
+// Get returns the cached body for key, if it has not expired.
+func (c *Cache) Get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+
+	if !c.clock.Now().Before(e.Expires) {
+		delete(c.entries, key)
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	return e.Body, true
+}
+
+// Stats returns the number of hits and misses of Get.
+func (c *Cache) Stats() (hits int64, misses int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hits, c.misses
+}
+
+// Set caches body for key until the TTL passes.
+// If the cache is full after removing expired entries, body is not cached.
+func (c *Cache) Set(key string, body []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.maxEntries {
+		c.prune(now)
+		if len(c.entries) >= c.maxEntries {
+			return
+		}
+	}
+
+	c.entries[key] = cacheEntry{Body: body, Expires: now.Add(c.entryTTL())}
+}
+
+// entryTTL returns the ttl of a new entry, c.mu must be held.
+func (c *Cache) entryTTL() time.Duration {
+	if c.jitter <= 0 {
+		return c.ttl
+	}
+	return c.ttl + time.Duration((rand.Float64()*2-1)*c.jitter*float64(c.ttl))
+}
+
+// SetLimits changes the ttl of new entries and the maximum number of entries.
+func (c *Cache) SetLimits(ttl time.Duration, maxEntries int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.ttl = ttl
+	c.maxEntries = maxEntries
+}
+
+// SetJitter changes the fraction of the ttl new entries vary by,
+// clamped between 0 and 1.
+func (c *Cache) SetJitter(jitter float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.jitter = math.Max(0, math.Min(jitter, 1))
+}
+
+// prune removes the expired entries, c.mu must be held.
+func (c *Cache) prune(now time.Time) {
+	for key, e := range c.entries {
+		if !now.Before(e.Expires) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// Save writes the entries that have not expired to the file at path.
+func (c *Cache) Save(path string) error {
+	c.mu.Lock()
+	c.prune(c.clock.Now())
+	b, err := json.Marshal(c.entries)
+	c.mu.Unlock()
+
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first, so a failed save keeps the old file
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load adds the entries saved at path that have not expired yet.
+func (c *Cache) Load(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var entries map[string]cacheEntry
+	if err = json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	for key, e := range entries {
+		if now.Before(e.Expires) && len(c.entries) < c.maxEntries {
+			c.entries[key] = e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCacheExpires(t *testing.T) {
+	clock := newFakeClock()
+	cache := newCache(time.Minute, 10, clock)
+
+	cache.Set("a", []byte("body"))
+	clock.Advance(59 * time.Second)
+	if body, ok := cache.Get("a"); !ok || string(body) != "body" {
+		t.Fatalf("Get before the ttl = %q, %v, want the body", body, ok)
+	}
+
+	clock.Advance(time.Second)
+	if _, ok := cache.Get("a"); ok {
+		t.Fatal("Get after the ttl found the entry")
+	}
+
+	hits, misses := cache.Stats()
+	if hits != 1 || misses != 1 {
+		t.Errorf("Stats = %d hits, %d misses, want 1 and 1", hits, misses)
+	}
+}
+
+func TestCacheJitter(t *testing.T) {
+	clock := newFakeClock()
+	cache := newCache(time.Minute, 100, clock)
+	cache.SetJitter(0.5)
+
+	for i := 0; i < 100; i++ {
+		cache.Set(string(rune('a'+i)), nil)
+	}
+	for key, e := range cache.entries {
+		ttl := e.Expires.Sub(clock.Now())
+		if ttl < 30*time.Second || ttl > 90*time.Second {
+			t.Errorf("entry %q expires after %v, want between 30s and 90s", key, ttl)
+		}
+	}
+}
+
+func TestCacheFull(t *testing.T) {
+	clock := newFakeClock()
+	cache := newCache(time.Minute, 1, clock)
+
+	cache.Set("a", nil)
+	cache.Set("b", nil)
+	if _, ok := cache.Get("b"); ok {
+		t.Fatal("full cache stored a new entry")
+	}
+
+	// Expired entries make room for new ones
+	clock.Advance(time.Minute)
+	cache.Set("b", nil)
+	if _, ok := cache.Get("b"); !ok {
+		t.Fatal("entry was not stored after the old one expired")
+	}
+}
+
+func TestCacheSaveLoad(t *testing.T) {
+	clock := newFakeClock()
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	cache := newCache(time.Minute, 10, clock)
+	cache.Set("old", []byte("old"))
+	clock.Advance(30 * time.Second)
+	cache.Set("new", []byte("new"))
+	if err := cache.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.Advance(45 * time.Second)
+	loaded := newCache(time.Minute, 10, clock)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.Get("old"); ok {
+		t.Error("Load kept an expired entry")
+	}
+	if body, ok := loaded.Get("new"); !ok || string(body) != "new" {
+		t.Errorf("Get(new) = %q, %v, want the saved body", body, ok)
+	}
+}
+
+func TestCacheSaveSkipsExpired(t *testing.T) {
+	clock := newFakeClock()
+	path := filepath.Join(t.TempDir(), "cache.json")
+
+	cache := newCache(time.Minute, 10, clock)
+	cache.Set("old", []byte("old"))
+	clock.Advance(time.Minute)
+	cache.Set("a", []byte("a"))
+	cache.Set("b", []byte("b"))
+	if err := cache.Save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the entries that fit are loaded
+	loaded := newCache(time.Minute, 1, clock)
+	if err := loaded.Load(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loaded.entries["old"]; ok || len(loaded.entries) != 1 {
+		t.Errorf("loaded entries = %v, want one of a and b", loaded.entries)
+	}
+
+	missing := newCache(time.Minute, 10, clock)
+	if err := missing.Load(filepath.Join(t.TempDir(), "missing.json")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Load of a missing file = %v, want os.ErrNotExist", err)
+	}
+}
+
+func TestCacheStats(t *testing.T) {
+	c := newCache(time.Minute, 100, newFakeClock())
+	c.Set("a", []byte("a"))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i%5 == 0 {
+				c.Get("b")
+			} else {
+				c.Get("a")
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if hits, misses := c.Stats(); hits != 40 || misses != 10 {
+		t.Errorf("Stats() = %d hits, %d misses, want 40, 10", hits, misses)
+	}
+}
+
+func TestCacheJitterSpreads(t *testing.T) {
+	tests := []struct {
+		jitter   float64
+		min, max time.Duration
+	}{
+		{0, time.Minute, time.Minute},
+		{-1, time.Minute, time.Minute},
+		{0.1, 54 * time.Second, 66 * time.Second},
+		{2, 0, 2 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		clock := newFakeClock()
+		cache := newCache(time.Minute, 1000, clock)
+		cache.SetJitter(tt.jitter)
+
+		ttls := map[time.Duration]bool{}
+		for i := 0; i < 200; i++ {
+			cache.Set(strconv.Itoa(i), nil)
+		}
+		for _, e := range cache.entries {
+			ttl := e.Expires.Sub(clock.Now())
+			ttls[ttl] = true
+			if ttl < tt.min || ttl > tt.max {
+				t.Errorf("entry with jitter %v expires after %v, want between %v and %v", tt.jitter, ttl, tt.min, tt.max)
+			}
+		}
+
+		if spread := len(ttls) > 1; spread != (tt.min != tt.max) {
+			t.Errorf("%d different ttls with jitter %v", len(ttls), tt.jitter)
+		}
+	}
+}
+
+func TestCacheJitterConfig(t *testing.T) {
+	newTestService(t, func(c *Config) {
+		c.CacheTTL = time.Minute
+		c.CacheJitter = 0.2
+	})
+
+	if dexClient.cache.jitter != 0.2 {
+		t.Errorf("jitter of the client cache = %v, want 0.2", dexClient.cache.jitter)
+	}
+}
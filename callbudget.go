@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+// Budgets shared by everything done for one request, kept in its context
+type (
+	callBudgetKey  struct{}
+	retryBudgetKey struct{}
+)
+
+// withBudget returns a context allowing max uses of the budget of key, or
+// ctx if max is 0.
+func withBudget(ctx context.Context, key interface{}, max int) context.Context {
+	if max <= 0 {
+		return ctx
+	}
+	remaining := int64(max)
+	return context.WithValue(ctx, key, &remaining)
+}
+
+// takeBudget uses up one of the budget of key in ctx, reporting false if
+// none is left. Contexts without the budget are not limited.
+func takeBudget(ctx context.Context, key interface{}) bool {
+	remaining, ok := ctx.Value(key).(*int64)
+	if !ok {
+		return true
+	}
+	return atomic.AddInt64(remaining, -1) >= 0
+}
+
+// withCallBudget returns a context allowing at most max uncached MangaDex
+// requests for everything done with it, or ctx if max is 0.
+func withCallBudget(ctx context.Context, max int) context.Context {
+	return withBudget(ctx, callBudgetKey{}, max)
+}
+
+// takeCall uses up one request of the budget of ctx, reporting false if
+// none is left.
+func takeCall(ctx context.Context) bool {
+	return takeBudget(ctx, callBudgetKey{})
+}
+
+// withRetryBudget returns a context allowing at most max retries of failed
+// MangaDex requests on another base, summed over all requests made with it,
+// or ctx if max is 0.
+func withRetryBudget(ctx context.Context, max int) context.Context {
+	return withBudget(ctx, retryBudgetKey{}, max)
+}
+
+// retriesLeft returns how many retries are left in the budget of ctx, or -1
+// if it has no budget.
+func retriesLeft(ctx context.Context) int {
+	remaining, ok := ctx.Value(retryBudgetKey{}).(*int64)
+	if !ok {
+		return -1
+	}
+	if n := atomic.LoadInt64(remaining); n > 0 {
+		return int(n)
+	}
+	return 0
+}
+
+// chargeRetries uses up n retries of the budget of ctx.
+func chargeRetries(ctx context.Context, n int) {
+	if remaining, ok := ctx.Value(retryBudgetKey{}).(*int64); ok && n > 0 {
+		atomic.AddInt64(remaining, -int64(n))
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCallBudget(t *testing.T) {
+	ctx := withCallBudget(context.Background(), 2)
+	for i, want := range []bool{true, true, false, false} {
+		if got := takeCall(ctx); got != want {
+			t.Errorf("takeCall %d = %v, want %v", i, got, want)
+		}
+	}
+
+	// Without a budget nothing is limited
+	unlimited := withCallBudget(context.Background(), 0)
+	for i := 0; i < 100; i++ {
+		if !takeCall(unlimited) {
+			t.Fatalf("takeCall %d without a budget = false", i)
+		}
+	}
+}
+
+func TestEmbedCallBudget(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.MaxUpstreamCalls = 3
+	})
+
+	const mangaId = "00000000-0000-4000-8000-000000000000"
+	var authors, rel []string
+	for i := 1; i <= 8; i++ {
+		id := fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+		name := fmt.Sprintf("Author %d", i)
+		authors = append(authors, id)
+		rel = append(rel, fmt.Sprintf(`{"id": %q, "type": "author"}`, id))
+		fixtures.handle("/author/"+id, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			fmt.Fprintf(w, `{"result": "ok", "data": {"id": %q, "type": "author", "attributes": {"name": %q}}}`, id, name)
+		})
+	}
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"result": "ok", "data": {"id": %q, "type": "manga", "attributes": {"title": {"en": "Many Authors"}}, "relationships": [%s]}}`, mangaId, strings.Join(rel, ","))
+	})
+
+	before := callBudgetExceeded.Value()
+	w := get(r, "/title/"+mangaId)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(), `<meta content="Many Authors - Author `)
+
+	// The manga and two authors fit in the budget
+	looked := 0
+	for _, id := range authors {
+		looked += fixtures.count("/author/" + id)
+	}
+	if looked != 2 {
+		t.Errorf("%d authors requested, want 2", looked)
+	}
+	if got := callBudgetExceeded.Value() - before; got != 6 {
+		t.Errorf("call_budget_exceeded increased by %d, want 6", got)
+	}
+
+	// Every request has its own budget
+	get(r, "/title/"+mangaId)
+	if n := fixtures.count("/manga/" + mangaId); n != 2 {
+		t.Errorf("manga requested %d times, want 2", n)
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	ctx := withRetryBudget(context.Background(), 3)
+	if got := retriesLeft(ctx); got != 3 {
+		t.Errorf("retriesLeft = %d, want 3", got)
+	}
+	chargeRetries(ctx, 2)
+	if got := retriesLeft(ctx); got != 1 {
+		t.Errorf("retriesLeft after 2 retries = %d, want 1", got)
+	}
+	chargeRetries(ctx, 5)
+	if got := retriesLeft(ctx); got != 0 {
+		t.Errorf("retriesLeft after using up the budget = %d, want 0", got)
+	}
+
+	unlimited := withRetryBudget(context.Background(), 0)
+	chargeRetries(unlimited, 5)
+	if got := retriesLeft(unlimited); got != -1 {
+		t.Errorf("retriesLeft without a budget = %d, want -1", got)
+	}
+}
+
+func TestEmbedRetryBudget(t *testing.T) {
+	// The oneshot has a single author and no cover, so the manga and then
+	// the author are requested
+	const mangaPath = "/manga/4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a"
+	const authorPath = "/author/9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+
+	tests := []struct {
+		budget   int
+		retries  int
+		exceeded int64
+		title    string
+	}{
+		// The author is not retried and left out
+		{1, 1, 1, `<meta content="Untitled Oneshot" property="og:title">`},
+		{2, 2, 0, `<meta content="Untitled Oneshot - Azuma Kiyohiko" property="og:title">`},
+		{0, 2, 0, `<meta content="Untitled Oneshot - Azuma Kiyohiko" property="og:title">`},
+	}
+
+	for _, tt := range tests {
+		primary := newFixtureServer(t)
+		for _, path := range []string{mangaPath, authorPath} {
+			primary.handle(path, func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusServiceUnavailable)
+			})
+		}
+		r, mirror := newTestService(t, func(c *Config) {
+			c.ApiMirrors = []string{c.ApiUrl}
+			c.ApiUrl = primary.URL
+			c.RetryBudget = tt.budget
+		})
+
+		before := retryBudgetExceeded.Value()
+		w := get(r, "/title/4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a")
+		if w.Code != http.StatusOK {
+			t.Fatalf("status with RETRY_BUDGET=%d = %d, want 200", tt.budget, w.Code)
+		}
+		assertContains(t, w.Body.String(), tt.title)
+
+		if n := mirror.count(mangaPath) + mirror.count(authorPath); n != tt.retries {
+			t.Errorf("%d requests retried with RETRY_BUDGET=%d, want %d", n, tt.budget, tt.retries)
+		}
+		if got := retryBudgetExceeded.Value() - before; got != tt.exceeded {
+			t.Errorf("retry_budget_exceeded increased by %d with RETRY_BUDGET=%d, want %d", got, tt.budget, tt.exceeded)
+		}
+	}
+}
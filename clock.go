@@ -0,0 +1,20 @@
+package main
+
+import "time"
+
+// Clock provides the current time, so that time dependent code
+// can be driven by a fake clock.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
@@ -0,0 +1,635 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Config holds the settings that can be changed through the environment.
+type Config struct {
+	// Either "file" to log to LogFile and stdout, or "stdout"
+	LogOutput string
+	LogFile   string
+	// Size in megabytes at which the log file is rotated
+	LogMaxSize int
+	// Days and number of rotated log files to keep, 0 keeps all
+	LogMaxAge     int
+	LogMaxBackups int
+
+	// Host or host:port to listen on, the port defaults to $PORT
+	ListenAddr string
+
+	// Base urls of the MangaDex API and the server hosting the covers,
+	// these can point to a local server with recorded responses
+	ApiUrl     string
+	UploadsUrl string
+
+	// Mirrors of the API tried in order when it fails, each but the last
+	// gets MirrorTimeout to respond
+	ApiMirrors    []string
+	MirrorTimeout time.Duration
+
+	// Proxy of all requests to MangaDex, HTTP_PROXY and HTTPS_PROXY are
+	// used if empty
+	OutboundProxy string
+
+	// Base url of the MangaDex site the embeds link to
+	SiteUrl string
+	// Url this service is publicly reached at, taken from the request if empty
+	PublicUrl string
+	// Path all routes are served under, e.g. "/md", empty for the root
+	BasePath string
+	// Redirect paths with a trailing slash, or with the wrong case or extra
+	// slashes, to the matching route instead of responding with 404
+	RedirectTrailingSlash bool
+	RedirectFixedPath     bool
+
+	// Preview of the index page
+	ServiceName    string
+	ServiceTagline string
+	ServiceImage   string
+
+	// Directory served on /static, if it exists
+	StaticDir string
+
+	// Ids that may be embedded, if non-empty every other id is refused
+	AllowList map[string]bool
+	// Ids that are never embedded, checked before the allowlist
+	DenyList map[string]bool
+
+	BlockedStatus  int
+	BlockedMessage string
+
+	// Embed manga that are not published, e.g. drafts, or are locked,
+	// instead of showing UnpublishedMessage
+	EmbedUnpublished   bool
+	EmbedLocked        bool
+	UnpublishedMessage string
+
+	// Serve the maintenance page for all embeds, also toggled on reload and
+	// through /maintenance
+	Maintenance        bool
+	MaintenanceMessage string
+
+	// Embed title of manga that MangaDex no longer has, and how long
+	// clients may cache it
+	UnavailableMessage  string
+	UnavailableCacheTTL time.Duration
+
+	// Embed title of user profiles that MangaDex does not show
+	PrivateProfileMessage string
+
+	// Line crediting the service in embeds, none if empty
+	Attribution string
+
+	// Ids and tags of manga that are served without a preview
+	NoEmbedIds  map[string]bool
+	NoEmbedTags []string
+
+	// Keys of the manga links to show, in order of preference
+	LinkKeys []string
+	MaxLinks int
+
+	// Requests waiting on the rate limiter before new ones get a 503
+	MaxWaiting int
+
+	// What to show for manga without a description, one of
+	// "alt_title", "tags", "placeholder" or "none"
+	DescriptionFallback    string
+	DescriptionPlaceholder string
+	// "first" or "longest" description when none is in a preferred language
+	DescriptionTiebreak string
+
+	AuthorFormat AuthorFormat
+
+	// Maximum number of tags shown, 0 for no limit
+	MaxTags int
+	// Show genre tags before theme tags
+	TagGenresFirst bool
+	// Tags that are shown as the type of the manga, if present
+	KindTags []string
+
+	// Color of the Discord embeds
+	DiscordColor int
+
+	// Maximum number of manga in a /titles embed
+	MaxGroupIds int
+	// Maximum number of related manga, e.g. sequels, looked up for an embed,
+	// 0 to disable
+	MaxRelated int
+
+	// Deadline for all MangaDex requests needed for a single embed
+	RequestTimeout time.Duration
+
+	// Requests per second to MangaDex and the burst allowed on top
+	RateLimit float64
+	RateBurst int
+
+	// Slow down when MangaDex reports a low remaining quota, to at least
+	// RateFloor requests per second
+	AdaptiveRateLimit bool
+	RateFloor         float64
+	LowQuota          int
+
+	// Maximum concurrent requests by kind, 0 for no limit
+	MangaConcurrency      int
+	AuthorConcurrency     int
+	CoverConcurrency      int
+	CoverProxyConcurrency int
+
+	// Maximum embed and cover requests handled at the same time, 0 for no
+	// limit, and how many may wait for a slot. Slots are shared by weight
+	MaxConcurrency int
+	MaxQueued      int
+	QueueWeights   map[string]int
+
+	// Maximum embeds rendered at the same time, 0 for no limit. Renders
+	// wait up to RenderQueueTimeout for a slot
+	RenderConcurrency  int
+	RenderQueueTimeout time.Duration
+
+	// Serve covers through /cover instead of linking to MangaDex
+	CoverProxy bool
+	// Limits on a single cover download of the cover proxy
+	CoverTimeout  time.Duration
+	CoverMaxBytes int64
+	// Width and jpeg quality the cover proxy transcodes to by default,
+	// covers are passed through unchanged if both are 0
+	CoverWidth   int
+	CoverQuality int
+	// Hosts that may use the cover proxy, any host if empty
+	CoverAllowedOrigins []string
+	CoverAllowNoReferer bool
+
+	// Maximum number of relationships looked up per embed, the cover and
+	// first author go first, 0 for no limit
+	MaxRelationships int
+	// Maximum uncached MangaDex requests per embed of any kind, 0 for no limit
+	MaxUpstreamCalls int
+	// Maximum requests per embed repeated on a mirror after failing, 0 for
+	// no limit
+	RetryBudget int
+
+	// Look up the covers of the manga and prefer one in a requested language
+	LocalizedCovers bool
+	// Image of embeds of manga without a cover
+	FallbackCover string
+
+	// Content ratings, e.g. pornographic, of manga whose cover is replaced
+	// by CoverPlaceholder in the cover proxy, or a grey image if empty
+	HiddenCoverRatings []string
+	CoverPlaceholder   string
+
+	// Append this many characters of the id to the embed title, 0 to disable
+	TitleIdLength int
+
+	// Languages of alternative titles that are never shown
+	HiddenTitleLanguages []string
+
+	// Show the romanized title if the title is not in the latin script
+	PreferRomaji bool
+	// Title case titles written entirely in upper or lower case
+	TitleCase bool
+	// Put the flag of the original language before the embed title
+	TitleFlag bool
+	// Whether the "localized" or "original" title is the embed title,
+	// the other is shown as subtitle
+	TitlePrimary string
+	// Overrides of the OpenGraph locale of MangaDex languages
+	Locales map[string]string
+	// Remove line breaks and control characters from titles and author names
+	SanitizeTitles bool
+	// Remove comments and indentation from the rendered templates
+	MinifyHTML bool
+	// Send Vary: Accept-Language with responses localized by the header, so
+	// shared caches keep a copy per language
+	VaryLanguage bool
+
+	// How long MangaDex responses are cached, 0 to disable the cache
+	CacheTTL        time.Duration
+	CacheMaxEntries int
+	// Fraction of CacheTTL by which entries expire earlier or later
+	CacheJitter float64
+	// How long an url whose response could not be parsed is not requested
+	// again, 0 to disable
+	MalformedCacheTTL time.Duration
+	// File the cache is saved to on shutdown and loaded from on startup
+	CacheFile string
+
+	// Consecutive failures of a MangaDex endpoint after which it is not
+	// requested for BreakerCooldown, 0 to disable
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	// Url every rendered embed is posted to, disabled if empty, and the
+	// number of events queued before new ones are dropped
+	WebhookUrl     string
+	WebhookQueue   int
+	WebhookTimeout time.Duration
+
+	// Bearer token for the admin endpoints, which are disabled if empty
+	AdminToken string
+	// Number of manga fetched at the same time by /warm
+	WarmConcurrency int
+
+	// Maximum size of the request headers
+	MaxHeaderBytes int
+	// Maximum size of the body of POST requests
+	MaxBodyBytes int64
+	// Limits on how long clients may take to send a request, to receive the
+	// response and how long idle connections are kept, 0 for no limit
+	ReadHeaderTimeout time.Duration
+	ReadTimeout       time.Duration
+	WriteTimeout      time.Duration
+	IdleTimeout       time.Duration
+
+	// Limits on the ignored :manga-name part of the path
+	MaxSlugLength int
+	SlugPattern   *regexp.Regexp
+}
+
+var config Config
+
+func loadConfig() Config {
+	return Config{
+		LogOutput:     envString("LOG_OUTPUT", "file"),
+		LogFile:       envString("LOG_FILE", "gin.log"),
+		LogMaxSize:    envInt("LOG_MAX_SIZE", 100),
+		LogMaxAge:     envInt("LOG_MAX_AGE", 0),
+		LogMaxBackups: envInt("LOG_MAX_BACKUPS", 3),
+
+		ListenAddr: envString("LISTEN_ADDR", ""),
+
+		ApiUrl:     strings.TrimSuffix(envString("API_URL", "https://api.mangadex.org"), "/"),
+		UploadsUrl: strings.TrimSuffix(envString("UPLOADS_URL", "https://uploads.mangadex.org"), "/"),
+
+		ApiMirrors:    trimSlashes(envList("API_MIRRORS", nil)),
+		MirrorTimeout: envDuration("MIRROR_TIMEOUT", 3*time.Second),
+
+		OutboundProxy: envString("OUTBOUND_PROXY", ""),
+
+		SiteUrl:   strings.TrimSuffix(envString("SITE_URL", "https://mangadex.org"), "/"),
+		PublicUrl: strings.TrimSuffix(envString("PUBLIC_URL", ""), "/"),
+		BasePath:  basePath(envString("BASE_PATH", "")),
+
+		RedirectTrailingSlash: envBool("REDIRECT_TRAILING_SLASH", true),
+		RedirectFixedPath:     envBool("REDIRECT_FIXED_PATH", false),
+
+		ServiceName:    envString("SERVICE_NAME", "Mangadex Embed"),
+		ServiceTagline: envString("SERVICE_TAGLINE", "Better embeds for MangaDex links, just append .njkyu.com after mangadex.org"),
+		ServiceImage:   envString("SERVICE_IMAGE", ""),
+
+		StaticDir: envString("STATIC_DIR", "static"),
+
+		AllowList:      envIdSet("ALLOWLIST"),
+		DenyList:       envIdSet("DENYLIST"),
+		BlockedStatus:  envStatus("BLOCKED_STATUS", http.StatusForbidden),
+		BlockedMessage: envString("BLOCKED_MESSAGE", "This title is not available for embedding"),
+		NoEmbedIds:     envIdSet("NOEMBED"),
+		NoEmbedTags:    envList("NOEMBED_TAGS", nil),
+		LinkKeys:       envList("LINK_KEYS", []string{"engtl", "raw", "al", "mal"}),
+		MaxLinks:       envInt("MAX_LINKS", 2),
+		MaxWaiting:     envInt("MAX_WAITING", 50),
+
+		Maintenance:        envBool("MAINTENANCE", false),
+		MaintenanceMessage: envString("MAINTENANCE_MESSAGE", "Down for maintenance, back soon."),
+
+		UnavailableMessage:  envString("UNAVAILABLE_MESSAGE", "This title is no longer available"),
+		UnavailableCacheTTL: envDuration("UNAVAILABLE_CACHE_TTL", 5*time.Minute),
+
+		PrivateProfileMessage: envString("PRIVATE_PROFILE_MESSAGE", "This profile is private"),
+
+		Attribution: envString("ATTRIBUTION", ""),
+
+		EmbedUnpublished:   envBool("EMBED_UNPUBLISHED", false),
+		EmbedLocked:        envBool("EMBED_LOCKED", true),
+		UnpublishedMessage: envString("UNPUBLISHED_MESSAGE", "This title is not published"),
+
+		DescriptionFallback:    envString("DESCRIPTION_FALLBACK", "alt_title"),
+		DescriptionTiebreak:    envString("DESCRIPTION_TIEBREAK", "first"),
+		DescriptionPlaceholder: envString("DESCRIPTION_PLACEHOLDER", "No description available"),
+
+		AuthorFormat: AuthorFormat{
+			Separator: envString("AUTHOR_SEPARATOR", " - "),
+			Joiner:    envString("AUTHOR_JOINER", ", "),
+			By:        envBool("AUTHOR_BY", false),
+			Max:       envInt("MAX_AUTHORS", 0),
+		},
+
+		MaxTags:        envInt("MAX_TAGS", 5),
+		TagGenresFirst: envBool("TAG_GENRES_FIRST", true),
+		KindTags:       envList("KIND_TAGS", []string{"Doujinshi", "Anthology"}),
+
+		DiscordColor: envInt("DISCORD_COLOR", 0xFF6740),
+
+		MaxGroupIds: envInt("MAX_GROUP_IDS", 10),
+		MaxRelated:  envInt("MAX_RELATED", 0),
+
+		RequestTimeout: envDuration("REQUEST_TIMEOUT", 10*time.Second),
+
+		RateLimit: envFloat("RATE_LIMIT", 0.5),
+		RateBurst: envInt("RATE_BURST", 5),
+
+		AdaptiveRateLimit: envBool("ADAPTIVE_RATE_LIMIT", false),
+		RateFloor:         envFloat("RATE_FLOOR", 0.1),
+		LowQuota:          envInt("LOW_QUOTA", 5),
+
+		MangaConcurrency:      envInt("MANGA_CONCURRENCY", 0),
+		AuthorConcurrency:     envInt("AUTHOR_CONCURRENCY", 0),
+		CoverConcurrency:      envInt("COVER_CONCURRENCY", 0),
+		CoverProxyConcurrency: envInt("COVER_PROXY_CONCURRENCY", 0),
+
+		MaxConcurrency: envInt("MAX_CONCURRENCY", 0),
+		MaxQueued:      envInt("MAX_QUEUED", 100),
+		QueueWeights: map[string]int{
+			"metadata": envInt("METADATA_WEIGHT", 1),
+			"proxy":    envInt("PROXY_WEIGHT", 1),
+		},
+
+		RenderConcurrency:  envInt("RENDER_CONCURRENCY", 0),
+		RenderQueueTimeout: envDuration("RENDER_QUEUE_TIMEOUT", time.Second),
+
+		CoverProxy:          envBool("COVER_PROXY", false),
+		CoverTimeout:        envDuration("COVER_TIMEOUT", 10*time.Second),
+		CoverMaxBytes:       int64(envInt("COVER_MAX_BYTES", 5<<20)),
+		CoverWidth:          envInt("COVER_WIDTH", 0),
+		CoverQuality:        envInt("COVER_QUALITY", 0),
+		CoverAllowedOrigins: envList("COVER_ALLOWED_ORIGINS", nil),
+		CoverAllowNoReferer: envBool("COVER_ALLOW_NO_REFERER", true),
+
+		MaxRelationships: envInt("MAX_RELATIONSHIPS", 10),
+		MaxUpstreamCalls: envInt("MAX_UPSTREAM_CALLS", 0),
+		RetryBudget:      envInt("RETRY_BUDGET", 0),
+
+		LocalizedCovers: envBool("LOCALIZED_COVERS", false),
+		FallbackCover:   envString("FALLBACK_COVER", ""),
+
+		HiddenCoverRatings: envList("HIDDEN_COVER_RATINGS", nil),
+		CoverPlaceholder:   envString("COVER_PLACEHOLDER", ""),
+
+		TitleIdLength: envInt("TITLE_ID_LENGTH", 0),
+
+		HiddenTitleLanguages: envList("HIDDEN_TITLE_LANGUAGES", nil),
+
+		PreferRomaji: envBool("PREFER_ROMAJI", false),
+		TitleCase:    envBool("TITLE_CASE", false),
+		TitleFlag:    envBool("TITLE_FLAG", false),
+		TitlePrimary: envString("TITLE_PRIMARY", "localized"),
+		Locales:      envLocales("LOCALES"),
+
+		SanitizeTitles: envBool("SANITIZE_TITLES", true),
+		VaryLanguage:   envBool("VARY_LANGUAGE", true),
+		MinifyHTML:     envBool("MINIFY_HTML", false),
+
+		CacheTTL:          envDuration("CACHE_TTL", 5*time.Minute),
+		CacheMaxEntries:   envInt("CACHE_MAX_ENTRIES", 10000),
+		CacheJitter:       envFloat("CACHE_JITTER", 0.1),
+		MalformedCacheTTL: envDuration("MALFORMED_CACHE_TTL", 30*time.Second),
+		CacheFile:         envString("CACHE_FILE", ""),
+
+		BreakerThreshold: envInt("BREAKER_THRESHOLD", 5),
+		BreakerCooldown:  envDuration("BREAKER_COOLDOWN", 30*time.Second),
+
+		WebhookUrl:     envString("WEBHOOK_URL", ""),
+		WebhookQueue:   envInt("WEBHOOK_QUEUE", 100),
+		WebhookTimeout: envDuration("WEBHOOK_TIMEOUT", 5*time.Second),
+
+		AdminToken:      envString("ADMIN_TOKEN", ""),
+		WarmConcurrency: envInt("WARM_CONCURRENCY", 4),
+
+		MaxHeaderBytes: envInt("MAX_HEADER_BYTES", 16<<10),
+		MaxBodyBytes:   int64(envInt("MAX_BODY_BYTES", 64<<10)),
+
+		ReadHeaderTimeout: envDuration("READ_HEADER_TIMEOUT", 5*time.Second),
+		ReadTimeout:       envDuration("READ_TIMEOUT", 10*time.Second),
+		WriteTimeout:      envDuration("WRITE_TIMEOUT", 30*time.Second),
+		IdleTimeout:       envDuration("IDLE_TIMEOUT", 2*time.Minute),
+
+		MaxSlugLength: envInt("MAX_SLUG_LENGTH", 200),
+		SlugPattern:   envRegexp("SLUG_PATTERN", `^[a-zA-Z0-9_-]*$`),
+	}
+}
+
+// basePath normalizes a path prefix to a leading and no trailing slash,
+// or an empty string for the root.
+func basePath(p string) string {
+	if p = strings.Trim(p, "/"); p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// trimSlashes removes the trailing slash of every url in the list.
+func trimSlashes(urls []string) []string {
+	for i, u := range urls {
+		urls[i] = strings.TrimSuffix(u, "/")
+	}
+	return urls
+}
+
+// validSlug reports whether the manga name part of the path is acceptable.
+func (c *Config) validSlug(slug string) bool {
+	return len(slug) <= c.MaxSlugLength && c.SlugPattern.MatchString(slug)
+}
+
+// isNoEmbed reports whether the manga should be served without a preview,
+// because of its id or one of its tags.
+func (c *Config) isNoEmbed(mangaId string, tags []Tag) bool {
+	if c.NoEmbedIds[strings.ToLower(mangaId)] {
+		return true
+	}
+	return contentKind(tags, c.NoEmbedTags) != ""
+}
+
+// hidesCover reports whether covers of manga with the content rating are
+// replaced by the placeholder.
+func (c *Config) hidesCover(rating string) bool {
+	for _, r := range c.HiddenCoverRatings {
+		if strings.EqualFold(r, rating) {
+			return true
+		}
+	}
+	return false
+}
+
+// hidesAltTitle reports whether alternative titles in lang are not shown.
+func (c *Config) hidesAltTitle(lang string) bool {
+	for _, l := range c.HiddenTitleLanguages {
+		if strings.EqualFold(l, lang) {
+			return true
+		}
+	}
+	return false
+}
+
+// isBlocked reports whether the given manga id may not be embedded.
+func (c *Config) isBlocked(mangaId string) bool {
+	id := strings.ToLower(mangaId)
+	if c.DenyList[id] {
+		return true
+	}
+	return len(c.AllowList) > 0 && !c.AllowList[id]
+}
+
+func envString(key string, def string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: invalid value for %s, using %d: %v\n", key, def, err)
+		return def
+	}
+	return i
+}
+
+// envStatus reads an HTTP status, only error statuses are accepted.
+func envStatus(key string, def int) int {
+	status := envInt(key, def)
+	if status < 400 || status > 599 {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: invalid value for %s, using %d: %d is not an error status\n", key, def, status)
+		return def
+	}
+	return status
+}
+
+func envFloat(key string, def float64) float64 {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: invalid value for %s, using %g: %v\n", key, def, err)
+		return def
+	}
+	return f
+}
+
+func envBool(key string, def bool) bool {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: invalid value for %s, using %t: %v\n", key, def, err)
+		return def
+	}
+	return b
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: invalid value for %s, using %s: %v\n", key, def, err)
+		return def
+	}
+	return d
+}
+
+func envRegexp(key string, def string) *regexp.Regexp {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return regexp.MustCompile(def)
+	}
+
+	re, err := regexp.Compile(v)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: invalid value for %s, using %s: %v\n", key, def, err)
+		return regexp.MustCompile(def)
+	}
+	return re
+}
+
+// envList reads a comma separated list, ignoring empty entries.
+func envList(key string, def []string) []string {
+	v, ok := os.LookupEnv(key)
+	if !ok {
+		return def
+	}
+
+	list := []string{}
+	for _, item := range strings.Split(v, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// envIdSet reads a set of ids from the comma separated variable `key`
+// and from the file named by `key`_FILE, containing one id per line.
+func envIdSet(key string) map[string]bool {
+	set := map[string]bool{}
+	for _, id := range strings.Split(os.Getenv(key), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			set[strings.ToLower(id)] = true
+		}
+	}
+
+	path := os.Getenv(key + "_FILE")
+	if path == "" {
+		return set
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: could not read %s_FILE: %v\n", key, err)
+		return set
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		set[strings.ToLower(line)] = true
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: could not read %s_FILE: %v\n", key, err)
+	}
+
+	return set
+}
+
+// envLocales reads comma separated `language=locale` pairs overriding
+// the default locales.
+func envLocales(key string) map[string]string {
+	locales := map[string]string{}
+	for _, pair := range envList(key, nil) {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 {
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: invalid value for %s, ignoring %q\n", key, pair)
+			continue
+		}
+		locales[strings.ToLower(strings.TrimSpace(parts[0]))] = strings.TrimSpace(parts[1])
+	}
+	return locales
+}
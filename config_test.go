@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestIsBlocked(t *testing.T) {
+	const (
+		allowed  = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+		denied   = "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f"
+		unlisted = "4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a"
+	)
+
+	tests := []struct {
+		name  string
+		allow map[string]bool
+		deny  map[string]bool
+		want  map[string]bool
+	}{
+		{
+			name: "denylist",
+			deny: map[string]bool{denied: true},
+			want: map[string]bool{allowed: false, denied: true, unlisted: false},
+		},
+		{
+			name:  "allowlist",
+			allow: map[string]bool{allowed: true},
+			want:  map[string]bool{allowed: false, denied: true, unlisted: true},
+		},
+		{
+			name:  "both",
+			allow: map[string]bool{allowed: true, denied: true},
+			deny:  map[string]bool{denied: true},
+			want:  map[string]bool{allowed: false, denied: true, unlisted: true},
+		},
+	}
+
+	for _, tt := range tests {
+		c := Config{AllowList: tt.allow, DenyList: tt.deny}
+		for id, want := range tt.want {
+			if got := c.isBlocked(id); got != want {
+				t.Errorf("%s: isBlocked(%s) = %t, want %t", tt.name, id, got, want)
+			}
+		}
+	}
+
+	c := Config{DenyList: map[string]bool{denied: true}}
+	if !c.isBlocked("2B8D4E6F-1A3C-4E5B-8D7F-9A0B1C2D3E4F") {
+		t.Error("uppercase id of the denylist is not blocked")
+	}
+}
+
+func TestEnvIdSet(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids")
+	err := os.WriteFile(path, []byte("# removed\n2B8D4E6F-1A3C-4E5B-8D7F-9A0B1C2D3E4F\n\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_IDS", " 7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d, ")
+	t.Setenv("TEST_IDS_FILE", path)
+
+	set := envIdSet("TEST_IDS")
+	if len(set) != 2 || !set["7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"] || !set["2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f"] {
+		t.Errorf("envIdSet = %v, want the id of the variable and of the file", set)
+	}
+}
+
+func TestEnvIdSetScanError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ids")
+	long := strings.Repeat("a", bufio.MaxScanTokenSize+1)
+	if err := os.WriteFile(path, []byte("7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d\n"+long+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_IDS", "")
+	t.Setenv("TEST_IDS_FILE", path)
+
+	var log bytes.Buffer
+	defer func(w io.Writer) { gin.DefaultWriter = w }(gin.DefaultWriter)
+	gin.DefaultWriter = &log
+
+	set := envIdSet("TEST_IDS")
+	if len(set) != 1 || !set["7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"] {
+		t.Errorf("envIdSet = %v, want the id before the long line", set)
+	}
+	if !strings.Contains(log.String(), "could not read TEST_IDS_FILE: "+bufio.ErrTooLong.Error()) {
+		t.Errorf("log = %q, want the scan error", log.String())
+	}
+}
+
+func TestEnvStatus(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int
+		warn  bool
+	}{
+		{"451", 451, false},
+		{"400", 400, false},
+		{"599", 599, false},
+		{"200", http.StatusForbidden, true},
+		{"302", http.StatusForbidden, true},
+		{"600", http.StatusForbidden, true},
+		{"-1", http.StatusForbidden, true},
+		{"gone", http.StatusForbidden, true},
+	}
+
+	var log bytes.Buffer
+	defer func(w io.Writer) { gin.DefaultWriter = w }(gin.DefaultWriter)
+	gin.DefaultWriter = &log
+
+	for _, tt := range tests {
+		log.Reset()
+		t.Setenv("TEST_STATUS", tt.value)
+		if got := envStatus("TEST_STATUS", http.StatusForbidden); got != tt.want {
+			t.Errorf("envStatus of %q = %d, want %d", tt.value, got, tt.want)
+		}
+		if warned := strings.Contains(log.String(), "invalid value for TEST_STATUS"); warned != tt.warn {
+			t.Errorf("envStatus of %q logged %q", tt.value, log.String())
+		}
+	}
+}
+
+func TestValidSlug(t *testing.T) {
+	c := Config{MaxSlugLength: 20, SlugPattern: regexp.MustCompile(`^[a-zA-Z0-9_-]*$`)}
+
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{"", true},
+		{"yotsuba", true},
+		{"Yotsuba_to-2", true},
+		{strings.Repeat("a", 20), true},
+		{strings.Repeat("a", 21), false},
+		{"yotsuba%20to", false},
+		{"yotsuba to", false},
+		{"<script>", false},
+		{"../etc", false},
+	}
+
+	for _, tt := range tests {
+		if got := c.validSlug(tt.slug); got != tt.want {
+			t.Errorf("validSlug(%q) = %t, want %t", tt.slug, got, tt.want)
+		}
+	}
+}
+
+func TestHidesAltTitle(t *testing.T) {
+	c := Config{HiddenTitleLanguages: []string{"ja-ro", "KO"}}
+
+	tests := []struct {
+		lang string
+		want bool
+	}{
+		{"ja-ro", true},
+		{"JA-RO", true},
+		{"ko", true},
+		{"ja", false},
+		{"ko-ro", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := c.hidesAltTitle(tt.lang); got != tt.want {
+			t.Errorf("hidesAltTitle(%q) = %t, want %t", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestBasePath(t *testing.T) {
+	tests := []struct {
+		path, want string
+	}{
+		{"", ""},
+		{"/", ""},
+		{"md", "/md"},
+		{"/md/", "/md"},
+		{"/md/embeds", "/md/embeds"},
+	}
+
+	for _, tt := range tests {
+		if got := basePath(tt.path); got != tt.want {
+			t.Errorf("basePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestServedUnderBasePath(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.BasePath = "/md"
+		c.CoverProxy = true
+	})
+
+	for path, want := range map[string]int{
+		"/md/":      http.StatusOK,
+		"/md/ready": http.StatusOK,
+		"/md/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d":                                          http.StatusOK,
+		"/md/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg": http.StatusOK,
+		"/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d":                                             http.StatusNotFound,
+		"/ready": http.StatusNotFound,
+	} {
+		if w := get(r, path); w.Code != want {
+			t.Errorf("status of %s = %d, want %d", path, w.Code, want)
+		}
+	}
+
+	// Links to the service include the base path
+	assertContains(t, get(r, "/md/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d").Body.String(),
+		`<meta content="http://example.com/md/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg" property='og:image'>`)
+	if loc := get(r, "/md/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/cover.jpg").Header().Get("Location"); loc != "http://example.com/md/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg" {
+		t.Errorf("Location of the cover = %q", loc)
+	}
+}
@@ -0,0 +1,441 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/valyala/fastjson"
+)
+
+var (
+	validId       = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	validFilename = regexp.MustCompile(`^[0-9a-zA-Z-]+\.(jpg|jpeg|png|gif|webp)(\.(256|512)\.jpg)?$`)
+)
+
+type coverArt struct {
+	url         string
+	volume      string
+	description string
+}
+
+// fetchCover looks up the cover with the given id of a manga. A cover without
+// a file name is returned as an empty cover, its url would not be valid.
+func fetchCover(ctx context.Context, mangaId string, coverId string) (coverArt, error) {
+	coverJSON, err := dexClient.RequestJSON(ctx, coverEndpoint, coverId)
+	if err != nil {
+		return coverArt{}, err
+	}
+
+	coverAttr := coverJSON.Get("data").Get("attributes")
+	filename := string(coverAttr.GetStringBytes("fileName"))
+	if filename == "" {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: cover %s of manga %s has no file name\n", coverId, mangaId)
+		return coverArt{}, nil
+	}
+	return coverArt{
+		url: config.UploadsUrl + fmt.Sprintf(CoverUri, mangaId, filename),
+		// Volume and description are null for most covers
+		volume:      string(coverAttr.GetStringBytes("volume")),
+		description: string(coverAttr.GetStringBytes("description")),
+	}, nil
+}
+
+// coverListEndpoint lists covers, the query is given as id
+const coverListEndpoint = "/cover?%s"
+
+// localeCover returns the latest cover of the manga in the first of langs
+// that has one, or an empty cover if there is none.
+func localeCover(ctx context.Context, mangaId string, langs []string) (coverArt, error) {
+	query := url.Values{}
+	query.Set("manga[]", mangaId)
+	query.Set("limit", "100")
+	query.Set("order[volume]", "desc")
+
+	list, err := dexClient.RequestJSON(ctx, coverListEndpoint, query.Encode())
+	if err != nil {
+		return coverArt{}, err
+	}
+
+	covers := list.GetArray("data")
+	for _, lang := range langs {
+		primary := strings.SplitN(lang, "-", 2)[0]
+		for _, v := range covers {
+			locale := strings.ToLower(string(v.GetStringBytes("attributes", "locale")))
+			if locale == lang || strings.SplitN(locale, "-", 2)[0] == primary {
+				return relationshipCover(ctx, mangaId, v)
+			}
+		}
+	}
+	return coverArt{}, nil
+}
+
+// relationshipCover returns the cover of a cover_art relationship, which is
+// only looked up if it was not included in the manga response.
+func relationshipCover(ctx context.Context, mangaId string, rel *fastjson.Value) (coverArt, error) {
+	attr := rel.Get("attributes")
+	filename := string(attr.GetStringBytes("fileName"))
+	if filename == "" {
+		coverId := string(rel.GetStringBytes("id"))
+		if coverId == "" {
+			emptyRelationshipIds.Add(1)
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: cover_art relationship of manga %s has no id\n", mangaId)
+			return coverArt{}, nil
+		}
+		return fetchCover(ctx, mangaId, coverId)
+	}
+
+	return coverArt{
+		url:         config.UploadsUrl + fmt.Sprintf(CoverUri, mangaId, filename),
+		volume:      string(attr.GetStringBytes("volume")),
+		description: string(attr.GetStringBytes("description")),
+	}, nil
+}
+
+// mangaCover looks up the current cover of the manga, which has an empty url
+// if the manga has none or it could not be looked up.
+func mangaCover(ctx context.Context, mangaId string) (coverArt, error) {
+	mangaJSON, err := dexClient.RequestJSON(ctx, mangaEndpoint, mangaId)
+	if err != nil {
+		return coverArt{}, err
+	}
+
+	rel := mangaJSON.Get("data").GetArray("relationships")
+	i := firstCover(rel)
+	if i < 0 {
+		return coverArt{}, nil
+	}
+
+	cover, err := relationshipCover(ctx, mangaId, rel[i])
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+	}
+	return cover, nil
+}
+
+// coverRedirect redirects to the current cover of the manga, through the
+// cover proxy if enabled. Both the manga and cover lookup are cached.
+func coverRedirect(c *gin.Context) {
+	mangaId := c.Param("md-id")
+	if config.isBlocked(mangaId) {
+		c.AbortWithStatus(config.BlockedStatus)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+	defer cancel()
+
+	cover, err := mangaCover(ctx, mangaId)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if cover.url == "" {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	target := cover.url
+	if config.CoverProxy {
+		target = proxiedCoverUrl(c, mangaId, cover.url)
+	}
+	c.Redirect(http.StatusFound, target)
+}
+
+// coverClient downloads the proxied covers, these are not rate limited
+// like the API.
+var coverClient = http.DefaultClient
+
+// coverProxySem limits the number of covers proxied at the same time.
+var coverProxySem semaphore
+
+// publicUrl returns the url this service is reached at.
+func publicUrl(c *gin.Context) string {
+	if config.PublicUrl != "" {
+		return config.PublicUrl
+	}
+
+	scheme := "http"
+	if c.Request.TLS != nil {
+		scheme = "https"
+	}
+	if proto := c.GetHeader("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+	return fmt.Sprintf("%s://%s", scheme, c.Request.Host)
+}
+
+// proxiedCoverUrl returns the url of the cover through the cover proxy,
+// with the ?w= and ?q= of the request. The embed image, and so the embed
+// data and its ETag, differ by cover size and quality.
+func proxiedCoverUrl(c *gin.Context, mangaId string, coverUrl string) string {
+	u := fmt.Sprintf("%s%s/cover/%s/%s", publicUrl(c), config.BasePath, mangaId, path.Base(coverUrl))
+	if query := coverQuery(c.Query("w"), c.Query("q")); query != "" {
+		u += "?" + query
+	}
+	return u
+}
+
+// coverQuery returns the ?w= and ?q= options of a proxied cover that were
+// given, clamped like the cover proxy does, so equal covers get equal urls.
+func coverQuery(width string, quality string) string {
+	query := url.Values{}
+	if w, err := strconv.Atoi(width); err == nil {
+		if w > 0 {
+			w = clamp(w, minCoverWidth, maxCoverWidth)
+		}
+		query.Set("w", strconv.Itoa(w))
+	}
+	if q, err := strconv.Atoi(quality); err == nil {
+		if q > 0 {
+			q = clamp(q, minCoverQuality, maxCoverQuality)
+		}
+		query.Set("q", strconv.Itoa(q))
+	}
+	return query.Encode()
+}
+
+// allowedReferer reports whether the cover proxy may serve a request with
+// the given Referer or Origin header. If no origins are configured all
+// requests are allowed, same host requests are always allowed.
+func allowedReferer(referer string, host string) bool {
+	if len(config.CoverAllowedOrigins) == 0 {
+		return true
+	}
+	if referer == "" {
+		// Crawlers fetching the embed image do not send a referer
+		return config.CoverAllowNoReferer
+	}
+
+	u, err := url.Parse(referer)
+	if err != nil {
+		return false
+	}
+
+	if strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, origin := range config.CoverAllowedOrigins {
+		if strings.EqualFold(u.Host, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// proxyCover streams a cover image from the MangaDex uploads server.
+func proxyCover(c *gin.Context) {
+	mangaId := c.Param("md-id")
+	filename := c.Param("filename")
+	if !validId.MatchString(mangaId) || !validFilename.MatchString(filename) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if !checkCoverRequest(c, mangaId) {
+		return
+	}
+
+	streamCover(c, mangaId, filename, 86400)
+}
+
+// coverImage responds with the current cover of the manga itself, for sites
+// that show images but no embeds. Manga without a cover get the placeholder.
+func coverImage(c *gin.Context) {
+	mangaId := c.Param("md-id")
+	if !validId.MatchString(mangaId) {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+
+	if !checkCoverRequest(c, mangaId) {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+	defer cancel()
+
+	cover, err := mangaCover(ctx, mangaId)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		c.AbortWithStatus(errorStatus(err))
+		return
+	}
+
+	if cover.url == "" {
+		body, contentType := coverPlaceholder()
+		c.Header("Cache-Control", "public, max-age=300")
+		c.Data(http.StatusOK, contentType, body)
+		return
+	}
+
+	// The manga may get a new cover, so it is cached for less long
+	streamCover(c, mangaId, path.Base(cover.url), 3600)
+}
+
+// checkCoverRequest responds to requests for a cover that is blocked, from a
+// referer that is not allowed or hidden by its content rating, and reports
+// whether the cover may be served.
+func checkCoverRequest(c *gin.Context, mangaId string) bool {
+	if config.isBlocked(mangaId) {
+		c.AbortWithStatus(config.BlockedStatus)
+		return false
+	}
+
+	referer := c.GetHeader("Referer")
+	if referer == "" {
+		referer = c.GetHeader("Origin")
+	}
+	if !allowedReferer(referer, c.Request.Host) {
+		c.AbortWithStatus(http.StatusForbidden)
+		return false
+	}
+
+	if hiddenCover(c, mangaId) {
+		body, contentType := coverPlaceholder()
+		c.Header("Cache-Control", "public, max-age=86400")
+		c.Data(http.StatusOK, contentType, body)
+		return false
+	}
+
+	return true
+}
+
+// streamCover downloads the cover from the MangaDex uploads server and
+// responds with it, cached for maxAge seconds.
+func streamCover(c *gin.Context, mangaId string, filename string, maxAge int) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.CoverTimeout)
+	defer cancel()
+
+	// Waiting for a slot would only hold more connections open, the
+	// client can retry or load the cover from MangaDex
+	if !coverProxySem.tryAcquire() {
+		coverProxyRejections.Add(1)
+		c.Header("Retry-After", "5")
+		c.AbortWithStatus(http.StatusServiceUnavailable)
+		return
+	}
+	defer coverProxySem.release()
+
+	req, _ := http.NewRequestWithContext(ctx, "GET", config.UploadsUrl+fmt.Sprintf(CoverUri, mangaId, filename), nil)
+	resp, err := coverClient.Do(req)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: could not fetch cover: %v\n", err)
+		c.AbortWithStatus(coverErrorStatus(ctx))
+		return
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: cover status not ok: %d\n", resp.StatusCode)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(contentType, "image/") {
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+
+	if resp.ContentLength > config.CoverMaxBytes {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: cover too large: %d bytes\n", resp.ContentLength)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+
+	// The body is read fully before responding, so a cover that turns out
+	// too large or too slow can still get an error status
+	body, err := io.ReadAll(io.LimitReader(resp.Body, config.CoverMaxBytes+1))
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: could not read cover: %v\n", err)
+		c.AbortWithStatus(coverErrorStatus(ctx))
+		return
+	}
+	if int64(len(body)) > config.CoverMaxBytes {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: cover larger than %d bytes\n", config.CoverMaxBytes)
+		c.AbortWithStatus(http.StatusBadGateway)
+		return
+	}
+
+	// Covers in a format the client does not accept are sent as jpeg,
+	// covers that can not be transcoded, e.g. webp, are served unchanged
+	c.Header("Vary", "Accept")
+	width, quality := coverOptions(c.Query("w"), c.Query("q"))
+	if quality == 0 && !acceptsType(c.GetHeader("Accept"), strings.SplitN(contentType, ";", 2)[0]) {
+		if !acceptsType(c.GetHeader("Accept"), "image/jpeg") {
+			c.AbortWithStatus(http.StatusNotAcceptable)
+			return
+		}
+		quality = jpeg.DefaultQuality
+	}
+	if quality > 0 {
+		if resized, err := transcodeCover(body, width, quality); err == nil {
+			body, contentType = resized, "image/jpeg"
+		} else {
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: could not transcode cover: %v\n", err)
+		}
+	}
+
+	c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", maxAge))
+	c.Data(http.StatusOK, contentType, body)
+}
+
+// acceptsType reports whether the Accept header allows the media type,
+// an empty header accepts everything.
+func acceptsType(accept string, mediaType string) bool {
+	if strings.TrimSpace(accept) == "" {
+		return true
+	}
+
+	mediaType = strings.ToLower(mediaType)
+	major := strings.SplitN(mediaType, "/", 2)[0]
+	for _, r := range strings.Split(accept, ",") {
+		params := strings.Split(r, ";")
+		if acceptQuality(params[1:]) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(strings.TrimSpace(params[0])) {
+		case "*/*", major + "/*", mediaType:
+			return true
+		}
+	}
+	return false
+}
+
+// acceptQuality returns the q parameter of a media range, 1 if it has none.
+func acceptQuality(params []string) float64 {
+	for _, p := range params {
+		if p = strings.TrimSpace(p); strings.HasPrefix(p, "q=") {
+			if q, err := strconv.ParseFloat(p[len("q="):], 64); err == nil {
+				return q
+			}
+		}
+	}
+	return 1
+}
+
+// coverErrorStatus returns the status for a failed cover download,
+// 504 if it did not finish in time.
+func coverErrorStatus(ctx context.Context) int {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return http.StatusGatewayTimeout
+	}
+	return http.StatusBadGateway
+}
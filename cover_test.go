@@ -0,0 +1,438 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestRelationshipCover(t *testing.T) {
+	_, fixtures := newTestService(t, nil)
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	tests := []struct {
+		name string
+		rel  string
+		want coverArt
+	}{
+		{
+			name: "included",
+			rel:  `{"id": "c1d2e3f4-a5b6-4c7d-8e9f-0a1b2c3d4e5f", "type": "cover_art", "attributes": {"volume": "15", "description": "Yotsuba and Jumbo", "fileName": "5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"}}`,
+			want: coverArt{
+				url:         fixtures.URL + "/covers/" + mangaId + "/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg",
+				volume:      "15",
+				description: "Yotsuba and Jumbo",
+			},
+		},
+		{
+			name: "without volume",
+			rel:  `{"id": "c1d2e3f4-a5b6-4c7d-8e9f-0a1b2c3d4e5f", "type": "cover_art", "attributes": {"volume": null, "description": null, "fileName": "5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"}}`,
+			want: coverArt{url: fixtures.URL + "/covers/" + mangaId + "/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"},
+		},
+		{
+			name: "looked up",
+			rel:  `{"id": "b3c4d5e6-f7a8-4b9c-8d0e-1f2a3b4c5d6e", "type": "cover_art"}`,
+			want: coverArt{
+				url:    fixtures.URL + "/covers/" + mangaId + "/8e7f9d3c-0a4b-4c5d-9e8f-3a4b5c6d7e8f.png",
+				volume: "3",
+			},
+		},
+		{
+			name: "without id",
+			rel:  `{"type": "cover_art"}`,
+		},
+	}
+
+	for _, tt := range tests {
+		got, err := relationshipCover(context.Background(), mangaId, fastjson.MustParse(tt.rel))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: relationshipCover = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+
+	if n := fixtures.count("/cover/c1d2e3f4-a5b6-4c7d-8e9f-0a1b2c3d4e5f"); n != 0 {
+		t.Errorf("included cover requested %d times, want 0", n)
+	}
+}
+
+func TestAllowedReferer(t *testing.T) {
+	config = loadConfig()
+	config.CoverAllowedOrigins = []string{"discord.com", "forum.example.org"}
+
+	tests := []struct {
+		referer   string
+		noReferer bool
+		want      bool
+	}{
+		{"https://discord.com/channels/1/2", true, true},
+		{"https://FORUM.example.org/thread/3", true, true},
+		{"https://embed.example.com/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", true, true},
+		{"https://images.example.net/", true, false},
+		{"https://discord.com.example.net/", true, false},
+		{"://bad", true, false},
+		{"", true, true},
+		{"", false, false},
+	}
+
+	for _, tt := range tests {
+		config.CoverAllowNoReferer = tt.noReferer
+		if got := allowedReferer(tt.referer, "embed.example.com"); got != tt.want {
+			t.Errorf("allowedReferer(%q) allowing none %t = %t, want %t", tt.referer, tt.noReferer, got, tt.want)
+		}
+	}
+
+	config.CoverAllowedOrigins = nil
+	if !allowedReferer("https://images.example.net/", "embed.example.com") {
+		t.Error("referer is checked without allowed origins")
+	}
+}
+
+func TestCoverProxyReferer(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.CoverAllowedOrigins = []string{"discord.com"}
+	})
+	const path = "/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"
+
+	tests := []struct {
+		header, value string
+		want          int
+	}{
+		{"Referer", "https://discord.com/channels/1/2", http.StatusOK},
+		{"Origin", "https://discord.com", http.StatusOK},
+		{"Referer", "http://example.com/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", http.StatusOK},
+		{"Referer", "https://images.example.net/", http.StatusForbidden},
+		{"Origin", "https://images.example.net", http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		if w := get(r, path, tt.header, tt.value); w.Code != tt.want {
+			t.Errorf("status with %s %s = %d, want %d", tt.header, tt.value, w.Code, tt.want)
+		}
+	}
+}
+
+func TestCoverRedirect(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.CacheTTL = time.Minute
+	})
+
+	for i := 0; i < 2; i++ {
+		w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/cover.jpg")
+		if w.Code != http.StatusFound {
+			t.Fatalf("status = %d, want 302", w.Code)
+		}
+		if loc := w.Header().Get("Location"); loc != fixtures.URL+"/covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg" {
+			t.Errorf("Location = %q", loc)
+		}
+	}
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); n != 1 {
+		t.Errorf("manga requested %d times, want 1", n)
+	}
+
+	config.CoverProxy = true
+	w := get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f/cover.jpg")
+	if loc := w.Header().Get("Location"); loc != "http://example.com/cover/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f/6d5f8c2b-9e3a-4f4b-8c7d-2e3f4a5b6c7d.jpg" {
+		t.Errorf("Location through the proxy = %q", loc)
+	}
+
+	for _, id := range []string{"4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a", "0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d"} {
+		if w := get(r, "/title/"+id+"/cover.jpg"); w.Code != http.StatusNotFound {
+			t.Errorf("status of %s without a cover = %d, want 404", id, w.Code)
+		}
+	}
+}
+
+func TestStreamCoverLimits(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.CoverTimeout = 50 * time.Millisecond
+		c.CoverMaxBytes = 16
+	})
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	// wait blocks until the cover request times out, or a second passes
+	wait := func(r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	}
+
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		want    int
+	}{
+		{"small", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("small cover"))
+		}, http.StatusOK},
+		{"content length too large", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte(strings.Repeat("x", 17)))
+		}, http.StatusBadGateway},
+		{"streamed too large", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			for i := 0; i < 4; i++ {
+				w.Write([]byte("12345678"))
+				w.(http.Flusher).Flush()
+			}
+		}, http.StatusBadGateway},
+		{"slow headers", func(w http.ResponseWriter, r *http.Request) {
+			wait(r)
+		}, http.StatusGatewayTimeout},
+		{"slow body", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write([]byte("part"))
+			w.(http.Flusher).Flush()
+			wait(r)
+		}, http.StatusGatewayTimeout},
+		{"not an image", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html>"))
+		}, http.StatusBadGateway},
+		{"upstream error", func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusInternalServerError)
+		}, http.StatusBadGateway},
+	}
+
+	for i, tt := range tests {
+		filename := fmt.Sprintf("%08d-0000-4000-8000-000000000000.png", i)
+		fixtures.handle("/covers/"+mangaId+"/"+filename, tt.handler)
+
+		if w := get(r, "/cover/"+mangaId+"/"+filename); w.Code != tt.want {
+			t.Errorf("status of a %s cover = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestEmbedCoverLookup(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	// The manga without the included cover, as without includes[]=cover_art
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	manga := fastjson.MustParseBytes(fixture)
+	cover := manga.Get("data").GetArray("relationships")[2]
+	cover.Del("attributes")
+	cover.Set("id", fastjson.MustParse(`"b3c4d5e6-f7a8-4b9c-8d0e-1f2a3b4c5d6e"`))
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(manga.MarshalTo(nil))
+	})
+
+	w := get(r, "/title/"+mangaId)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(), fixtures.URL+"/covers/"+mangaId+"/8e7f9d3c-0a4b-4c5d-9e8f-3a4b5c6d7e8f.png")
+	if n := fixtures.count("/cover/b3c4d5e6-f7a8-4b9c-8d0e-1f2a3b4c5d6e"); n != 1 {
+		t.Errorf("cover requested %d times, want 1", n)
+	}
+}
+
+func TestLocaleCover(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.LocalizedCovers = true
+	})
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	var query string
+	fixtures.handle("/cover", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.RawQuery
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "ok", "response": "collection", "data": [
+			{"id": "d1e2f3a4-b5c6-4d7e-8f9a-0b1c2d3e4f5a", "type": "cover_art", "attributes": {"volume": "15", "fileName": "ja-15.jpg", "locale": "ja"}},
+			{"id": "e2f3a4b5-c6d7-4e8f-9a0b-1c2d3e4f5a6b", "type": "cover_art", "attributes": {"volume": "14", "fileName": "fr-14.jpg", "locale": "fr"}},
+			{"id": "f3a4b5c6-d7e8-4f9a-0b1c-2d3e4f5a6b7c", "type": "cover_art", "attributes": {"volume": "13", "fileName": "pt-br-13.jpg", "locale": "pt-br"}}
+		]}`))
+	})
+
+	tests := []struct {
+		langs []string
+		file  string
+	}{
+		{[]string{"fr"}, "fr-14.jpg"},
+		{[]string{"de", "fr"}, "fr-14.jpg"},
+		{[]string{"pt-br"}, "pt-br-13.jpg"},
+		{[]string{"pt"}, "pt-br-13.jpg"},
+		{[]string{"fr-ca"}, "fr-14.jpg"},
+		{[]string{"de"}, ""},
+	}
+
+	for _, tt := range tests {
+		cover, err := localeCover(context.Background(), mangaId, tt.langs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := ""
+		if tt.file != "" {
+			want = fixtures.URL + "/covers/" + mangaId + "/" + tt.file
+		}
+		if cover.url != want {
+			t.Errorf("localeCover(%q) = %q, want %q", tt.langs, cover.url, want)
+		}
+	}
+	if !strings.Contains(query, "manga%5B%5D="+mangaId) {
+		t.Errorf("cover list query = %q, want the covers of the manga", query)
+	}
+
+	assertContains(t, get(r, "/title/"+mangaId, "Accept-Language", "fr").Body.String(), fixtures.URL+"/covers/"+mangaId+"/fr-14.jpg")
+	// Without a cover in the language the main cover is kept
+	assertContains(t, get(r, "/title/"+mangaId, "Accept-Language", "de").Body.String(), fixtures.URL+"/covers/"+mangaId+"/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg")
+
+	config.LocalizedCovers = false
+	assertContains(t, get(r, "/title/"+mangaId, "Accept-Language", "fr").Body.String(), fixtures.URL+"/covers/"+mangaId+"/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg")
+}
+
+func TestCoverProxyShedding(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.CoverProxyConcurrency = 1
+	})
+	const path = "/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"
+
+	release := make(chan struct{})
+	fixtures.handle("/covers"+path, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("cover"))
+	})
+
+	first := make(chan int)
+	go func() {
+		first <- get(r, "/cover"+path).Code
+	}()
+	for deadline := time.Now().Add(5 * time.Second); fixtures.count("/covers"+path) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+
+	before := coverProxyRejections.Value()
+	w := get(r, "/cover"+path)
+	if w.Code != http.StatusServiceUnavailable || w.Header().Get("Retry-After") != "5" {
+		t.Errorf("cover while streaming another = %d with Retry-After %q, want 503 with 5", w.Code, w.Header().Get("Retry-After"))
+	}
+	if n := coverProxyRejections.Value() - before; n != 1 {
+		t.Errorf("cover_proxy_rejections increased by %d, want 1", n)
+	}
+
+	// Embeds do not take a cover stream
+	if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusOK {
+		t.Errorf("status of an embed while streaming a cover = %d, want 200", w.Code)
+	}
+
+	close(release)
+	if code := <-first; code != http.StatusOK {
+		t.Errorf("status of the streamed cover = %d, want 200", code)
+	}
+	if w := get(r, "/cover"+path); w.Code != http.StatusOK {
+		t.Errorf("status after the stream finished = %d, want 200", w.Code)
+	}
+}
+
+func TestCoverImage(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	w := get(r, "/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if w.Code != http.StatusOK || w.Body.String() != "cover of /covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg" {
+		t.Errorf("cover = %d %q, want the current cover", w.Code, w.Body)
+	}
+	if ct, cc := w.Header().Get("Content-Type"), w.Header().Get("Cache-Control"); ct != "image/jpeg" || cc != "public, max-age=3600" {
+		t.Errorf("cover served as %q with %q, want image/jpeg for an hour", ct, cc)
+	}
+	if vary := w.Header().Get("Vary"); vary != "Accept" {
+		t.Errorf("Vary = %q, want Accept", vary)
+	}
+
+	// Manga without a cover get the placeholder
+	w = get(r, "/cover/4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a")
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || w.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Errorf("cover without one = %d %q %q, want the placeholder for 5 minutes", w.Code, w.Header().Get("Content-Type"), w.Header().Get("Cache-Control"))
+	}
+	if n := fixtures.count("/covers/4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a/"); n != 0 {
+		t.Errorf("uploads requested %d times for a manga without a cover", n)
+	}
+
+	if w := get(r, "/cover/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d"); w.Code != http.StatusNotFound {
+		t.Errorf("cover of a missing manga = %d, want 404", w.Code)
+	}
+	if w := get(r, "/cover/not-an-id"); w.Code != http.StatusNotFound {
+		t.Errorf("cover of an invalid id = %d, want 404", w.Code)
+	}
+
+	// Clients that only take png get no jpeg
+	if w := get(r, "/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "Accept", "image/png"); w.Code != http.StatusNotAcceptable {
+		t.Errorf("cover for a client without jpeg = %d, want 406", w.Code)
+	}
+}
+
+func TestAcceptsType(t *testing.T) {
+	tests := []struct {
+		accept, mediaType string
+		want              bool
+	}{
+		{"", "image/webp", true},
+		{"*/*", "image/webp", true},
+		{"image/*", "image/webp", true},
+		{"image/avif,image/webp,*/*;q=0.8", "image/jpeg", true},
+		{"image/png", "image/jpeg", false},
+		{"image/png, image/jpeg;q=0", "image/jpeg", false},
+		{"IMAGE/JPEG", "image/jpeg", true},
+		{"text/html", "image/jpeg", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsType(tt.accept, tt.mediaType); got != tt.want {
+			t.Errorf("acceptsType(%q, %q) = %t, want %t", tt.accept, tt.mediaType, got, tt.want)
+		}
+	}
+}
+
+func TestEmptyCoverFileName(t *testing.T) {
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The cover is looked up, and its fixture has an empty fileName
+	v := fastjson.MustParseBytes(fixture)
+	v.Get("data", "relationships", "2").Set("id", fastjson.MustParse(`"d7e8f9a0-b1c2-4d3e-8f4a-5b6c7d8e9f0a"`))
+	v.Get("data", "relationships", "2", "attributes").Set("fileName", fastjson.MustParse(`""`))
+	fixture = v.MarshalTo(nil)
+
+	r, fixtures := newTestService(t, nil)
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(fixture)
+	})
+
+	body := get(r, "/title/"+mangaId).Body.String()
+	assertContains(t, body, `<meta content="https://example.com/fallback.png" property='og:image'>`)
+	if strings.Contains(body, "/covers/"+mangaId+"/'") || strings.Contains(body, "/covers/"+mangaId+`/"`) {
+		t.Errorf("embed has a cover url without a file name:\n%s", body)
+	}
+	if n := fixtures.count("/cover/d7e8f9a0-b1c2-4d3e-8f4a-5b6c7d8e9f0a"); n != 1 {
+		t.Errorf("cover requested %d times, want 1", n)
+	}
+
+	w := get(r, "/cover/"+mangaId)
+	if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "image/png" || w.Header().Get("Cache-Control") != "public, max-age=300" {
+		t.Errorf("cover without a file name = %d %q %q, want the placeholder", w.Code, w.Header().Get("Content-Type"), w.Header().Get("Cache-Control"))
+	}
+	if n := fixtures.count("/covers/" + mangaId + "/"); n != 0 {
+		t.Errorf("uploads requested %d times for a cover without a file name", n)
+	}
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Limits of a Discord embed, see
+// https://discord.com/developers/docs/resources/channel#embed-object-embed-limits
+const (
+	discordTitleLimit       = 256
+	discordDescriptionLimit = 4096
+	discordFieldNameLimit   = 256
+	discordFieldValueLimit  = 1024
+	discordFooterLimit      = 2048
+	discordTotalLimit       = 6000
+)
+
+type discordImage struct {
+	Url string `json:"url"`
+}
+
+type discordField struct {
+	Name   string `json:"name"`
+	Value  string `json:"value"`
+	Inline bool   `json:"inline"`
+}
+
+type discordFooter struct {
+	Text string `json:"text"`
+}
+
+type discordEmbed struct {
+	Title       string         `json:"title"`
+	Description string         `json:"description,omitempty"`
+	Url         string         `json:"url"`
+	Color       int            `json:"color"`
+	Image       *discordImage  `json:"image,omitempty"`
+	Fields      []discordField `json:"fields,omitempty"`
+	Footer      *discordFooter `json:"footer,omitempty"`
+}
+
+// truncate shortens s to at most max characters, ending it with an ellipsis if cut.
+func truncate(s string, max int) string {
+	if utf8.RuneCountInString(s) <= max {
+		return s
+	}
+	if max <= 0 {
+		return ""
+	}
+
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
+
+// newDiscordEmbed converts the embed data to a Discord embed within its limits.
+func newDiscordEmbed(data gin.H, color int) discordEmbed {
+	str := func(key string) string {
+		s, _ := data[key].(string)
+		return s
+	}
+
+	embed := discordEmbed{
+		Title: truncate(str("og_title"), discordTitleLimit),
+		Url:   str("redirect"),
+		Color: color,
+	}
+
+	if image := str("og_image"); image != "" {
+		embed.Image = &discordImage{Url: image}
+	}
+
+	for _, f := range []struct{ name, key string }{
+		{"Status", "status"},
+		{"Tags", "tags"},
+		{"Type", "kind"},
+	} {
+		if value := str(f.key); value != "" {
+			embed.Fields = append(embed.Fields, discordField{
+				Name:   truncate(f.name, discordFieldNameLimit),
+				Value:  truncate(value, discordFieldValueLimit),
+				Inline: true,
+			})
+		}
+	}
+
+	if attribution := str("attribution"); attribution != "" {
+		embed.Footer = &discordFooter{Text: truncate(attribution, discordFooterLimit)}
+	}
+
+	// The description gets whatever is left of the total limit
+	used := utf8.RuneCountInString(embed.Title)
+	if embed.Footer != nil {
+		used += utf8.RuneCountInString(embed.Footer.Text)
+	}
+	for _, f := range embed.Fields {
+		used += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+
+	limit := discordDescriptionLimit
+	if discordTotalLimit-used < limit {
+		limit = discordTotalLimit - used
+	}
+	desc := str("og_content")
+	if subtitle := str("subtitle"); subtitle != "" {
+		desc = subtitle + "\n\n" + desc
+	}
+	embed.Description = truncate(desc, limit)
+
+	return embed
+}
+
+// discordEmbedJSON returns the manga as a Discord embed object,
+// which can be sent as is in the embeds of a webhook.
+func discordEmbedJSON(c *gin.Context) {
+	comicMeta, status := resolveEmbed(c, c.Param("md-id"))
+	if comicMeta == nil || status != http.StatusOK {
+		c.AbortWithStatus(status)
+		return
+	}
+
+	c.JSON(http.StatusOK, newDiscordEmbed(comicMeta, config.DiscordColor))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"Yotsuba", 10, "Yotsuba"},
+		{"Yotsuba", 7, "Yotsuba"},
+		{"Yotsuba to", 8, "Yotsuba…"},
+		{"よつばと！", 3, "よつ…"},
+		{"Yotsuba", 0, ""},
+	}
+
+	for _, tt := range tests {
+		if got := truncate(tt.s, tt.max); got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestDiscordEmbedLimits(t *testing.T) {
+	embed := newDiscordEmbed(gin.H{
+		"og_title":    strings.Repeat("t", 300),
+		"og_content":  strings.Repeat("d", 5000),
+		"subtitle":    "Subtitle",
+		"redirect":    "https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"og_image":    "https://uploads.mangadex.org/covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/cover.jpg",
+		"tags":        strings.Repeat("g", 1500),
+		"status":      "Ongoing",
+		"attribution": strings.Repeat("a", 2100),
+	}, 0xff6740)
+
+	if n := utf8.RuneCountInString(embed.Title); n != discordTitleLimit {
+		t.Errorf("title has %d characters, want %d", n, discordTitleLimit)
+	}
+	if n := utf8.RuneCountInString(embed.Footer.Text); n != discordFooterLimit {
+		t.Errorf("footer has %d characters, want %d", n, discordFooterLimit)
+	}
+	if !strings.HasPrefix(embed.Description, "Subtitle\n\n") {
+		t.Errorf("description does not start with the subtitle: %.20q", embed.Description)
+	}
+
+	total := utf8.RuneCountInString(embed.Title) + utf8.RuneCountInString(embed.Description) + utf8.RuneCountInString(embed.Footer.Text)
+	for _, f := range embed.Fields {
+		if n := utf8.RuneCountInString(f.Value); n > discordFieldValueLimit {
+			t.Errorf("field %s has %d characters, want at most %d", f.Name, n, discordFieldValueLimit)
+		}
+		total += utf8.RuneCountInString(f.Name) + utf8.RuneCountInString(f.Value)
+	}
+	if total > discordTotalLimit {
+		t.Errorf("embed has %d characters, want at most %d", total, discordTotalLimit)
+	}
+}
+
+func TestDiscordEmbed(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	w := get(r, "/discord/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+
+	var embed discordEmbed
+	if err := json.Unmarshal(w.Body.Bytes(), &embed); err != nil {
+		t.Fatal(err)
+	}
+	want := discordEmbed{
+		Title:       "Yotsuba&! - Azuma Kiyohiko",
+		Description: "よつばと！\n\nYotsuba is a strange little girl with a big heart.",
+		Url:         "https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		Color:       config.DiscordColor,
+		Image:       &discordImage{Url: fixtures.URL + "/covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"},
+		Fields: []discordField{
+			{Name: "Status", Value: "Ongoing", Inline: true},
+			{Name: "Tags", Value: "Comedy, Slice of Life", Inline: true},
+		},
+	}
+	got, _ := json.Marshal(embed)
+	expected, _ := json.Marshal(want)
+	if string(got) != string(expected) {
+		t.Errorf("embed = %s, want %s", got, expected)
+	}
+
+	if w := get(r, "/discord/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d"); w.Code != http.StatusNotFound {
+		t.Errorf("status of a missing manga = %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+type errorPage struct {
+	title   string
+	content string
+}
+
+// errorPages are the default messages by status, other statuses use the
+// message of their class
+var errorPages = map[int]errorPage{
+	http.StatusBadRequest:          {"Invalid request", "This is not a valid MangaDex link."},
+	http.StatusNotFound:            {"Not found", "There is nothing at this address."},
+	http.StatusInternalServerError: {"Something went wrong", "The preview could not be created, please try again later."},
+	http.StatusServiceUnavailable:  {"Temporarily unavailable", "MangaDex is busy, please try again in a moment."},
+}
+
+// renderError renders the error page for status, using the template
+// error_<status>.html if there is one and error.html otherwise.
+// The default message of the status is replaced by detail if not empty.
+func renderError(c *gin.Context, status int, detail string) {
+	page, ok := errorPages[status]
+	if !ok {
+		page = errorPages[status/100*100]
+	}
+	if page.title == "" {
+		page = errorPages[http.StatusInternalServerError]
+	}
+	if detail != "" {
+		page.content = detail
+	}
+
+	name := fmt.Sprintf("error_%d.html", status)
+	if templates == nil || templates.Lookup(name) == nil {
+		name = "error.html"
+	}
+
+	c.HTML(status, name, gin.H{
+		"og_title":   page.title,
+		"og_content": page.content,
+		"og_name":    config.ServiceName,
+		"status":     status,
+		"request_id": c.GetString("request_id"),
+	})
+	c.Abort()
+}
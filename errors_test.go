@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRenderError(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	r.GET("/status/:code", func(c *gin.Context) {
+		code, _ := strconv.Atoi(c.Param("code"))
+		renderError(c, code, c.Query("detail"))
+	})
+
+	tests := []struct {
+		path   string
+		status int
+		title  string
+		detail string
+	}{
+		{"/status/400", 400, "Invalid request", "This is not a valid MangaDex link."},
+		{"/status/404", 404, "Not found", "There is nothing at this address."},
+		{"/status/500", 500, "Something went wrong", "The preview could not be created, please try again later."},
+		{"/status/503", 503, "Temporarily unavailable", "MangaDex is busy, please try again in a moment."},
+		// Other statuses get the page of their class
+		{"/status/429", 429, "Invalid request", "This is not a valid MangaDex link."},
+		{"/status/502", 502, "Something went wrong", "The preview could not be created, please try again later."},
+		{"/status/404?detail=Gone", 404, "Not found", "Gone"},
+	}
+
+	for _, tt := range tests {
+		w := get(r, tt.path)
+		if w.Code != tt.status {
+			t.Errorf("status of %s = %d, want %d", tt.path, w.Code, tt.status)
+		}
+		assertContains(t, w.Body.String(),
+			fmt.Sprintf(`<meta content="%s" property="og:title">`, tt.title),
+			fmt.Sprintf(`<meta content="%s" property="og:description">`, tt.detail),
+		)
+	}
+}
+
+func TestRenderErrorTemplate(t *testing.T) {
+	newTestService(t, nil)
+
+	dir := t.TempDir()
+	for _, name := range requiredTemplates {
+		b, err := os.ReadFile(filepath.Join("templates", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := `<html><head><meta content="{{ .og_title }}" property="og:title"></head><body>page {{ .status }}</body></html>`
+	if err := os.WriteFile(filepath.Join(dir, "error_404.html"), []byte(page), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, err := loadTemplates(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates = tmpl
+	r := newRouter(tmpl)
+
+	w := get(r, "/no/such/page")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+	assertContains(t, w.Body.String(), "page 404")
+
+	// Statuses without their own template use error.html
+	w = get(r, "/user/not-an-id")
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("status = %d, want 400", w.Code)
+	}
+	assertContains(t, w.Body.String(), `<h1>Invalid request</h1>`, `<p>This is not a valid MangaDex user.</p>`)
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ErrQueueFull is returned when MaxQueued requests are already waiting.
+var ErrQueueFull = errors.New("request queue is full")
+
+// queueClass holds the requests of one kind waiting for a slot.
+type queueClass struct {
+	weight  int
+	waiting []chan struct{}
+}
+
+// fairQueue limits the number of requests handled at the same time. When
+// all slots are taken requests wait in the queue of their class, and freed
+// slots go to the classes in turn, weight requests of a class at a time, so
+// a burst of one kind does not starve the others. A nil fairQueue does not
+// limit anything.
+type fairQueue struct {
+	mu        sync.Mutex
+	free      int
+	maxQueued int
+	queued    int
+
+	classes map[string]*queueClass
+	order   []string
+	// Class whose turn it is and how many of its requests got a slot
+	next   int
+	served int
+}
+
+// newFairQueue returns a queue of slots slots and at most maxQueued waiting
+// requests, with the weights of the classes of requests it handles.
+func newFairQueue(slots int, maxQueued int, weights map[string]int) *fairQueue {
+	if slots <= 0 {
+		return nil
+	}
+
+	q := &fairQueue{
+		free:      slots,
+		maxQueued: maxQueued,
+		classes:   map[string]*queueClass{},
+	}
+	for _, name := range []string{"metadata", "proxy"} {
+		weight := weights[name]
+		if weight < 1 {
+			weight = 1
+		}
+		q.classes[name] = &queueClass{weight: weight}
+		q.order = append(q.order, name)
+	}
+	return q
+}
+
+// acquire takes a slot for a request of the class, waiting in its queue
+// until one is free or ctx is done.
+func (q *fairQueue) acquire(ctx context.Context, class string) error {
+	if q == nil {
+		return nil
+	}
+
+	q.mu.Lock()
+	if q.free > 0 && q.queued == 0 {
+		q.free--
+		q.mu.Unlock()
+		return nil
+	}
+	if q.queued >= q.maxQueued {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	ready := make(chan struct{})
+	c := q.classes[class]
+	c.waiting = append(c.waiting, ready)
+	q.queued++
+	q.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i, w := range c.waiting {
+		if w == ready {
+			c.waiting = append(c.waiting[:i], c.waiting[i+1:]...)
+			q.queued--
+			return ctx.Err()
+		}
+	}
+
+	// The slot was handed over while giving up, so it goes to the next
+	q.handOver()
+	return ctx.Err()
+}
+
+// release frees a slot taken by acquire.
+func (q *fairQueue) release() {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.handOver()
+}
+
+// handOver gives a freed slot to the next waiting request in turn, or keeps
+// it free if none are waiting. q.mu must be held.
+func (q *fairQueue) handOver() {
+	for n := 0; n < 2*len(q.order); n++ {
+		c := q.classes[q.order[q.next]]
+		if len(c.waiting) > 0 && q.served < c.weight {
+			ready := c.waiting[0]
+			c.waiting = c.waiting[1:]
+			q.queued--
+			q.served++
+			close(ready)
+			return
+		}
+
+		q.next = (q.next + 1) % len(q.order)
+		q.served = 0
+	}
+	q.free++
+}
+
+// requestQueue is shared by all embed and cover requests.
+var requestQueue *fairQueue
+
+// admit lets requests of the class through the request queue, responding
+// with 503 if the queue is full or the client gave up while waiting.
+func admit(class string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := requestQueue.acquire(c.Request.Context(), class); err != nil {
+			queueRejections.Add(1)
+			c.Header("Retry-After", "1")
+			c.AbortWithStatus(http.StatusServiceUnavailable)
+			return
+		}
+		defer requestQueue.release()
+
+		c.Next()
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// waitQueued waits until n requests are waiting in the queue.
+func waitQueued(t *testing.T, q *fairQueue, n int) {
+	deadline := time.Now().Add(time.Second)
+	for {
+		q.mu.Lock()
+		queued := q.queued
+		q.mu.Unlock()
+		if queued == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d requests queued, want %d", queued, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFairQueueWeights(t *testing.T) {
+	q := newFairQueue(1, 10, map[string]int{"metadata": 2, "proxy": 1})
+	if err := q.acquire(context.Background(), "metadata"); err != nil {
+		t.Fatal(err)
+	}
+
+	admitted := make(chan string)
+	for i, class := range []string{"metadata", "metadata", "metadata", "metadata", "proxy", "proxy", "proxy", "proxy"} {
+		class := class
+		go func() {
+			if err := q.acquire(context.Background(), class); err != nil {
+				t.Error(err)
+			}
+			admitted <- class
+		}()
+		waitQueued(t, q, i+1)
+	}
+
+	var order []string
+	for i := 0; i < 8; i++ {
+		q.release()
+		order = append(order, <-admitted)
+	}
+
+	// Two metadata requests for every proxy request, until only proxy
+	// requests are left
+	want := []string{"metadata", "metadata", "proxy", "metadata", "metadata", "proxy", "proxy", "proxy"}
+	if !reflect.DeepEqual(order, want) {
+		t.Errorf("admitted %q, want %q", order, want)
+	}
+
+	q.release()
+	if q.free != 1 {
+		t.Errorf("%d slots free after all released, want 1", q.free)
+	}
+}
+
+func TestFairQueueFull(t *testing.T) {
+	q := newFairQueue(1, 1, nil)
+	if err := q.acquire(context.Background(), "proxy"); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- q.acquire(context.Background(), "metadata") }()
+	waitQueued(t, q, 1)
+
+	if err := q.acquire(context.Background(), "proxy"); !errors.Is(err, ErrQueueFull) {
+		t.Errorf("acquire with a full queue = %v, want %v", err, ErrQueueFull)
+	}
+
+	q.release()
+	if err := <-done; err != nil {
+		t.Errorf("queued acquire = %v, want nil", err)
+	}
+
+	// A request giving up leaves the queue
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := q.acquire(ctx, "proxy"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("acquire after the deadline = %v, want %v", err, context.DeadlineExceeded)
+	}
+	waitQueued(t, q, 0)
+
+	q.release()
+	if err := q.acquire(context.Background(), "proxy"); err != nil {
+		t.Errorf("acquire of a free slot = %v, want nil", err)
+	}
+}
+
+func TestFairQueueUnlimited(t *testing.T) {
+	q := newFairQueue(0, 0, nil)
+	if q != nil {
+		t.Fatal("queue without slots is not nil")
+	}
+	for i := 0; i < 100; i++ {
+		if err := q.acquire(context.Background(), "metadata"); err != nil {
+			t.Fatalf("acquire of a nil queue = %v", err)
+		}
+	}
+	q.release()
+}
+
+func TestAdmit(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.MaxConcurrency = 1
+		c.MaxQueued = 0
+	})
+	if err := requestQueue.acquire(context.Background(), "proxy"); err != nil {
+		t.Fatal(err)
+	}
+
+	before := queueRejections.Value()
+	w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status with a full queue = %d, want 503", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+	if got := queueRejections.Value() - before; got != 1 {
+		t.Errorf("queue_rejections increased by %d, want 1", got)
+	}
+
+	requestQueue.release()
+	if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusOK {
+		t.Errorf("status with a free slot = %d, want 200", w.Code)
+	}
+	if requestQueue.free != 1 {
+		t.Errorf("%d slots free after the request, want 1", requestQueue.free)
+	}
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/valyala/fastjson"
+)
+
+const mangaFeedEndpoint = "/manga/%s/feed?limit=20&order[publishAt]=desc"
+
+var feedLanguage = regexp.MustCompile(`^[a-z]{2}(-[a-z]{2})?$`)
+
+type rssFeed struct {
+	XMLName xml.Name   `xml:"rss"`
+	Version string     `xml:"version,attr"`
+	Channel rssChannel `xml:"channel"`
+}
+
+type rssChannel struct {
+	Title       string    `xml:"title"`
+	Link        string    `xml:"link"`
+	Description string    `xml:"description"`
+	Items       []rssItem `xml:"item"`
+}
+
+type rssItem struct {
+	Title   string `xml:"title"`
+	Link    string `xml:"link"`
+	GUID    string `xml:"guid"`
+	PubDate string `xml:"pubDate,omitempty"`
+}
+
+// chapterTitle formats a chapter as e.g. "Vol. 1 Ch. 3 - Title".
+func chapterTitle(attr *fastjson.Value) string {
+	var parts []string
+	if volume := string(attr.GetStringBytes("volume")); volume != "" {
+		parts = append(parts, "Vol. "+volume)
+	}
+	if chapter := string(attr.GetStringBytes("chapter")); chapter != "" {
+		parts = append(parts, "Ch. "+chapter)
+	}
+
+	title := strings.Join(parts, " ")
+	if name := string(attr.GetStringBytes("title")); name != "" {
+		if title != "" {
+			title += " - "
+		}
+		title += name
+	}
+
+	if title == "" {
+		title = "Oneshot"
+	}
+	return title
+}
+
+// buildFeed creates the feed from the manga and manga feed responses, with
+// the title localized to langs like the embed.
+func buildFeed(manga *fastjson.Value, chapters *fastjson.Value, mangaId string, langs []string) rssFeed {
+	attr := manga.Get("data").Get("attributes")
+
+	// Taking the last language of the object would not be stable
+	title, _ := localize(attr.GetObject("title"), langs)
+
+	feed := rssFeed{
+		Version: "2.0",
+		Channel: rssChannel{
+			Title:       title,
+			Link:        fmt.Sprintf("%s/title/%s", config.SiteUrl, mangaId),
+			Description: fmt.Sprintf("Latest chapters of %s on MangaDex", title),
+		},
+	}
+
+	for _, ch := range chapters.GetArray("data") {
+		chAttr := ch.Get("attributes")
+		link := fmt.Sprintf("%s/chapter/%s", config.SiteUrl, ch.GetStringBytes("id"))
+
+		item := rssItem{
+			Title: chapterTitle(chAttr),
+			Link:  link,
+			GUID:  link,
+		}
+
+		if published, err := time.Parse(time.RFC3339, string(chAttr.GetStringBytes("publishAt"))); err == nil {
+			item.PubDate = published.Format(time.RFC1123Z)
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	return feed
+}
+
+// createFeed renders an RSS feed of the latest chapters of a manga,
+// optionally only those translated to ?lang=.
+func createFeed(c *gin.Context) {
+	mangaId := strings.TrimSuffix(c.Param("feed"), ".xml")
+	if mangaId == c.Param("feed") {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	if !validId.MatchString(mangaId) {
+		renderError(c, http.StatusBadRequest, "This is not a valid MangaDex title.")
+		return
+	}
+
+	if config.isBlocked(mangaId) {
+		c.AbortWithStatus(config.BlockedStatus)
+		return
+	}
+
+	endpoint := mangaFeedEndpoint
+	if lang := c.Query("lang"); lang != "" {
+		if !feedLanguage.MatchString(lang) {
+			c.String(http.StatusBadRequest, "invalid language")
+			return
+		}
+		endpoint += "&translatedLanguage[]=" + lang
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+	defer cancel()
+
+	mangaJSON, err := dexClient.RequestJSON(ctx, mangaEndpoint, mangaId)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		c.AbortWithStatus(errorStatus(err))
+		return
+	}
+
+	feedJSON, err := dexClient.RequestJSON(ctx, endpoint, mangaId)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		c.AbortWithStatus(errorStatus(err))
+		return
+	}
+
+	b, err := xml.MarshalIndent(buildFeed(mangaJSON, feedJSON, mangaId, requestLanguages(c)), "", "  ")
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+		return
+	}
+
+	c.Data(http.StatusOK, "application/rss+xml; charset=utf-8", append([]byte(xml.Header), b...))
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/xml"
+	"net/http"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestChapterTitle(t *testing.T) {
+	tests := []struct {
+		attr string
+		want string
+	}{
+		{`{"volume": "1", "chapter": "3", "title": "Yotsuba & Moving"}`, "Vol. 1 Ch. 3 - Yotsuba & Moving"},
+		{`{"volume": null, "chapter": "3", "title": ""}`, "Ch. 3"},
+		{`{"volume": null, "chapter": null, "title": "Extra"}`, "Extra"},
+		{`{"volume": null, "chapter": null, "title": null}`, "Oneshot"},
+	}
+
+	for _, tt := range tests {
+		if got := chapterTitle(fastjson.MustParse(tt.attr)); got != tt.want {
+			t.Errorf("chapterTitle(%s) = %q, want %q", tt.attr, got, tt.want)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	w := get(r, "/feed/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d.xml")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/rss+xml; charset=utf-8" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	var feed rssFeed
+	if err := xml.Unmarshal(w.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("feed is not valid XML: %v", err)
+	}
+	if feed.Version != "2.0" || feed.Channel.Title != "Yotsuba&!" || feed.Channel.Link != "https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d" {
+		t.Errorf("channel = %+v", feed.Channel)
+	}
+
+	want := []rssItem{
+		{
+			Title:   "Vol. 15 Ch. 105 - Yotsuba & Pancakes",
+			Link:    "https://mangadex.org/chapter/0e5b7c3a-6b1f-4a7d-9c2e-3f4a5b6c7d8e",
+			GUID:    "https://mangadex.org/chapter/0e5b7c3a-6b1f-4a7d-9c2e-3f4a5b6c7d8e",
+			PubDate: "Sat, 05 Mar 2022 18:30:00 +0000",
+		},
+		{
+			Title:   "Oneshot",
+			Link:    "https://mangadex.org/chapter/e1f2a3b4-c5d6-4e7f-8a9b-0c1d2e3f4a5b",
+			GUID:    "https://mangadex.org/chapter/e1f2a3b4-c5d6-4e7f-8a9b-0c1d2e3f4a5b",
+			PubDate: "Thu, 01 Feb 2018 00:00:00 +0000",
+		},
+	}
+	if len(feed.Channel.Items) != len(want) {
+		t.Fatalf("feed has %d items, want %d", len(feed.Channel.Items), len(want))
+	}
+	for i, item := range feed.Channel.Items {
+		if item != want[i] {
+			t.Errorf("item %d = %+v, want %+v", i, item, want[i])
+		}
+	}
+
+	if w := get(r, "/feed/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d.xml?lang=english"); w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid language = %d, want 400", w.Code)
+	}
+	if w := get(r, "/feed/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusNotFound {
+		t.Errorf("status without .xml = %d, want 404", w.Code)
+	}
+	for _, id := range []string{"yotsuba", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d%3Flimit=1"} {
+		w := get(r, "/feed/"+id+".xml")
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status of id %s = %d, want 400", id, w.Code)
+		}
+		assertContains(t, w.Body.String(), "This is not a valid MangaDex title.")
+	}
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/feed"); n != 1 {
+		t.Errorf("feed requested %d times, want 1", n)
+	}
+}
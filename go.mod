@@ -3,7 +3,13 @@ module github.com/nickyu42/md-rec/v2
 go 1.17
 
 require github.com/gin-gonic/gin v1.7.7
-require github.com/valyala/fastjson v1.6.3
+
+require (
+	github.com/valyala/fastjson v1.6.3
+	golang.org/x/sync v0.1.0
+	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8
+	gopkg.in/natefinch/lumberjack.v2 v2.2.1
+)
 
 require (
 	github.com/gin-contrib/sse v0.1.0 // indirect
@@ -17,9 +23,7 @@ require (
 	github.com/modern-go/concurrent v0.0.0-20180228061459-e0a39a4cb421 // indirect
 	github.com/modern-go/reflect2 v0.0.0-20180701023420-4b7aa43c6742 // indirect
 	github.com/ugorji/go/codec v1.1.7 // indirect
-	github.com/valyala/fastjson v1.6.3 // indirect
 	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9 // indirect
 	golang.org/x/sys v0.0.0-20200116001909-b77594299b42 // indirect
-	golang.org/x/time v0.0.0-20220210224613-90d013bbcef8 // indirect
 	gopkg.in/yaml.v2 v2.2.8 // indirect
 )
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/valyala/fastjson"
+)
+
+// mangaListEndpoint is filled with the query of the manga list request
+const mangaListEndpoint = "/manga?%s"
+
+// parseIds splits a comma separated list of manga ids, returning an error
+// if any of them is invalid or there are more than max.
+func parseIds(raw string, max int) ([]string, error) {
+	var ids []string
+	for _, id := range strings.Split(raw, ",") {
+		if id = strings.TrimSpace(id); id == "" {
+			continue
+		}
+		if !validId.MatchString(id) {
+			return nil, fmt.Errorf("invalid manga id: %q", id)
+		}
+		ids = append(ids, strings.ToLower(id))
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("no manga ids")
+	}
+	if len(ids) > max {
+		return nil, fmt.Errorf("at most %d manga ids are allowed", max)
+	}
+	return ids, nil
+}
+
+// inlineCover returns the cover url of a manga fetched with includes[]=cover_art.
+func inlineCover(manga *fastjson.Value) string {
+	for _, v := range manga.GetArray("relationships") {
+		if string(v.GetStringBytes("type")) != "cover_art" {
+			continue
+		}
+
+		if filename := string(v.Get("attributes").GetStringBytes("fileName")); filename != "" {
+			return config.UploadsUrl + fmt.Sprintf(CoverUri, manga.GetStringBytes("id"), filename)
+		}
+	}
+	return ""
+}
+
+// groupEmbed builds a single embed listing the manga of a list response.
+func groupEmbed(list *fastjson.Value, langs []string) gin.H {
+	var titles []string
+	var links []string
+	cover := ""
+	for _, manga := range list.GetArray("data") {
+		title, _ := localize(manga.Get("attributes").GetObject("title"), langs)
+		if config.SanitizeTitles {
+			title = sanitizeTitle(title)
+		}
+		titles = append(titles, "• "+title)
+		links = append(links, fmt.Sprintf("%s/title/%s", config.SiteUrl, manga.GetStringBytes("id")))
+
+		if cover == "" {
+			cover = inlineCover(manga)
+		}
+	}
+
+	return gin.H{
+		"og_title":   fmt.Sprintf("%d titles", len(titles)),
+		"og_content": strings.Join(titles, "\n"),
+		"og_name":    config.SiteUrl,
+		"og_image":   cover,
+		"og_links":   links,
+	}
+}
+
+// createGroupEmbed renders one embed for the manga in ?ids=, fetched in a
+// single list request.
+func createGroupEmbed(c *gin.Context) {
+	ids, err := parseIds(c.Query("ids"), config.MaxGroupIds)
+	if err != nil {
+		renderError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	query := url.Values{}
+	for _, id := range ids {
+		if config.isBlocked(id) {
+			renderError(c, config.BlockedStatus, config.BlockedMessage)
+			return
+		}
+		query.Add("ids[]", id)
+	}
+	query.Set("limit", strconv.Itoa(len(ids)))
+	query.Add("includes[]", "cover_art")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+	defer cancel()
+
+	list, err := dexClient.RequestJSON(ctx, mangaListEndpoint, query.Encode())
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		renderError(c, errorStatus(err), "")
+		return
+	}
+
+	c.HTML(http.StatusOK, "embed.html", groupEmbed(list, requestLanguages(c)))
+}
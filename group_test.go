@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseIds(t *testing.T) {
+	ids, err := parseIds(" 7F3C1B2E-5D4A-4C8E-9A6B-1E2F3A4B5C6D, ,2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f,", 2)
+	want := []string{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f"}
+	if err != nil || !reflect.DeepEqual(ids, want) {
+		t.Errorf("parseIds = %v, %v, want %v", ids, err, want)
+	}
+
+	for _, raw := range []string{
+		"",
+		" , ",
+		"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d,yotsuba",
+		"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d,2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f,4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a",
+	} {
+		if ids, err := parseIds(raw, 2); err == nil {
+			t.Errorf("parseIds(%q) = %v, want an error", raw, ids)
+		}
+	}
+}
+
+func TestGroupEmbed(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.MaxGroupIds = 2
+	})
+
+	var requested []string
+	fixtures.handle("/manga", func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.Query()["ids[]"]
+		body, _ := os.ReadFile(filepath.Join("testdata", "mangadex", "manga.json"))
+		w.Write(body)
+	})
+
+	w := get(r, "/titles?ids=7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d,2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`<meta content="2 titles" property="og:title">`,
+		"<meta content=\"• Yotsuba&amp;!\n• Death Note\" property=\"og:description\">",
+		`content="`+fixtures.URL+`/covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"`,
+	)
+
+	// Both are fetched in one request
+	if n := fixtures.count("/manga"); n != 1 {
+		t.Errorf("list requested %d times, want 1", n)
+	}
+	if want := []string{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f"}; !reflect.DeepEqual(requested, want) {
+		t.Errorf("requested ids %v, want %v", requested, want)
+	}
+
+	for _, query := range []string{"", "?ids=yotsuba", "?ids=" + strings.Repeat("7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d,", 3)} {
+		if w := get(r, "/titles"+query); w.Code != http.StatusBadRequest {
+			t.Errorf("status of /titles%.30s = %d, want 400", query, w.Code)
+		}
+	}
+}
+
+func TestGroupEmbedBlocked(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.DenyList = map[string]bool{"2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f": true}
+	})
+
+	w := get(r, "/titles?ids=7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d,2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	if w.Code != config.BlockedStatus {
+		t.Fatalf("status = %d, want %d", w.Code, config.BlockedStatus)
+	}
+	assertContains(t, w.Body.String(), config.BlockedMessage)
+	if n := fixtures.count("/manga"); n != 0 {
+		t.Errorf("list requested %d times, want 0", n)
+	}
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fixtureServer serves the recorded MangaDex responses in testdata/mangadex
+// as both the API and the uploads server, counting the requests by path.
+// Paths without a fixture get the 404 MangaDex responds with, handlers
+// added with handle replace the fixture of their path.
+type fixtureServer struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	requests map[string]int
+	handlers map[string]http.HandlerFunc
+}
+
+func newFixtureServer(t *testing.T) *fixtureServer {
+	s := &fixtureServer{requests: map[string]int{}, handlers: map[string]http.HandlerFunc{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serve))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *fixtureServer) serve(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	s.requests[r.URL.Path]++
+	handler := s.handlers[r.URL.Path]
+	s.mu.Unlock()
+
+	if handler != nil {
+		handler(w, r)
+		return
+	}
+
+	if strings.HasPrefix(r.URL.Path, "/covers/") {
+		w.Header().Set("Content-Type", "image/jpeg")
+		w.Write([]byte("cover of " + r.URL.Path))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	body, err := os.ReadFile(filepath.Join("testdata", "mangadex", filepath.FromSlash(r.URL.Path)+".json"))
+	if err != nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"result":"error","errors":[{"id":"e3f2c1b0-9a8b-4c7d-8e6f-5a4b3c2d1e0f","status":404,"title":"Not found","detail":"Resource could not be found","context":null}]}`))
+		return
+	}
+	w.Write(body)
+}
+
+// handle serves path with handler instead of its fixture.
+func (s *fixtureServer) handle(path string, handler http.HandlerFunc) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[path] = handler
+}
+
+// count returns the number of requests for path.
+func (s *fixtureServer) count(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// newTestService sets up the config, client and router against the fixture
+// server, after changing the default config with configure if not nil.
+func newTestService(t *testing.T, configure func(*Config)) (*gin.Engine, *fixtureServer) {
+	gin.SetMode(gin.TestMode)
+	gin.DefaultWriter = io.Discard
+
+	fixtures := newFixtureServer(t)
+	config = loadConfig()
+	config.ApiUrl = fixtures.URL
+	config.UploadsUrl = fixtures.URL
+	config.RateLimit = 1000
+	config.RateBurst = 1000
+	config.CacheTTL = 0
+	config.FallbackCover = "https://example.com/fallback.png"
+	if configure != nil {
+		configure(&config)
+	}
+
+	createDexClient(io.Discard)
+	dexClient.client = fixtures.Client()
+	coverClient = fixtures.Client()
+	webhook = nil
+
+	tmpl, err := loadTemplates("templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	templates = tmpl
+
+	return newRouter(tmpl), fixtures
+}
+
+// get requests path from the router with the headers, given as name and
+// value pairs.
+func get(r http.Handler, path string, headers ...string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	return w
+}
+
+// assertContains fails the test for each of want that is not in the body.
+func assertContains(t *testing.T, body string, want ...string) {
+	t.Helper()
+	for _, s := range want {
+		if !strings.Contains(body, s) {
+			t.Errorf("body does not contain %q:\n%s", s, body)
+		}
+	}
+}
+
+func TestEmbedAuthorAndCover(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`,
+		`Yotsuba is a strange little girl with a big heart.`,
+		fixtures.URL+`/covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg`,
+		`https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d`,
+	)
+
+	// The cover is included in the manga response, the artist is the author
+	if n := fixtures.count("/author/9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"); n != 1 {
+		t.Errorf("author requested %d times, want 1", n)
+	}
+	if n := fixtures.count("/cover/c1d2e3f4-a5b6-4c7d-8e9f-0a1b2c3d4e5f"); n != 0 {
+		t.Errorf("included cover requested %d times, want 0", n)
+	}
+}
+
+func TestEmbedMultipleAuthors(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	w := get(r, "/api/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`"og_title":"Death Note - Ohba Tsugumi, Obata Takeshi"`,
+		`"og_image_alt":"Volume 1 cover: Light and Ryuk"`,
+	)
+
+	w = get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(), `<meta content="Death Note - Ohba Tsugumi, Obata Takeshi" property="og:title">`)
+}
+
+func TestEmbedMissingCover(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	w := get(r, "/title/4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`<meta content="Untitled Oneshot - Azuma Kiyohiko" property="og:title">`,
+		`<meta content="https://example.com/fallback.png" property='og:image'>`,
+	)
+}
+
+func TestEmbedNotFound(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	w := get(r, "/title/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d")
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want 404", w.Code)
+	}
+	assertContains(t, w.Body.String(), config.UnavailableMessage)
+}
+
+func TestEmbedBlocked(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.DenyList = map[string]bool{"2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f": true}
+		c.AllowList = map[string]bool{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d": true}
+	})
+
+	for _, id := range []string{"2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f", "4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a"} {
+		w := get(r, "/title/"+id)
+		if w.Code != config.BlockedStatus {
+			t.Errorf("status of %s = %d, want %d", id, w.Code, config.BlockedStatus)
+		}
+		assertContains(t, w.Body.String(), config.BlockedMessage)
+		if n := fixtures.count("/manga/" + id); n != 0 {
+			t.Errorf("blocked %s requested %d times, want 0", id, n)
+		}
+	}
+
+	if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusOK {
+		t.Errorf("status of the allowed id = %d, want 200", w.Code)
+	}
+}
+
+func TestEmbedEmptyDescription(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.DescriptionFallback = "placeholder"
+	})
+
+	w := get(r, "/title/5e7a9c1b-3d5f-4b7a-9c1e-3f5a7c9e1b3d")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(), `No description available" property="og:description"`)
+}
+
+func TestEmbedAliases(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	for _, path := range []string{
+		"/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/yotsuba",
+		"/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/yotsuba",
+		"/titles/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"/titles/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/yotsuba",
+	} {
+		w := get(r, path)
+		if w.Code != http.StatusOK {
+			t.Errorf("status of %s = %d, want 200", path, w.Code)
+			continue
+		}
+		assertContains(t, w.Body.String(),
+			`<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`,
+			`url='https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d'`,
+		)
+	}
+}
+
+func TestEmbedInvalidSlug(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	for _, slug := range []string{strings.Repeat("a", config.MaxSlugLength+1), "yotsuba%3Cb%3E", "yotsuba.html"} {
+		w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/"+slug)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("status of slug %.20q = %d, want 400", slug, w.Code)
+		}
+	}
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); n != 0 {
+		t.Errorf("manga of invalid slugs requested %d times, want 0", n)
+	}
+}
+
+func TestEmbedNoEmbed(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.NoEmbedIds = map[string]bool{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d": true}
+		c.NoEmbedTags = []string{"Doujinshi"}
+	})
+
+	for _, id := range []string{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "9f0a1b2c-3d4e-4f5a-8b6c-7d8e9f0a1b2c"} {
+		w := get(r, "/title/"+id)
+		if w.Code != http.StatusOK {
+			t.Errorf("status of %s = %d, want 200", id, w.Code)
+		}
+		if strings.Contains(w.Body.String(), "og:") {
+			t.Errorf("page of %s has OpenGraph tags:\n%s", id, w.Body)
+		}
+		assertContains(t, w.Body.String(), `url='https://mangadex.org/title/`+id+`'`)
+	}
+
+	// Flagged ids are not looked up at all
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); n != 0 {
+		t.Errorf("flagged id requested %d times, want 0", n)
+	}
+
+	w := get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	assertContains(t, w.Body.String(), `property="og:title"`)
+}
+
+func TestIndex(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	w := get(r, "/")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`<meta content="Mangadex Embed" property="og:title">`,
+		`<meta content="`+config.ServiceTagline+`" property="og:description">`,
+		`<meta content="Mangadex Embed" property="og:site_name">`,
+		`<meta content="Mangadex Embed" name="twitter:title">`,
+	)
+	if strings.Contains(w.Body.String(), `property="og:image"`) {
+		t.Error("index has an image without SERVICE_IMAGE")
+	}
+
+	r, _ = newTestService(t, func(c *Config) {
+		c.ServiceName = "Embeds"
+		c.ServiceImage = "https://embed.example.com/logo.png"
+	})
+	assertContains(t, get(r, "/").Body.String(),
+		`<meta content="Embeds" property="og:title">`,
+		`<meta content="https://embed.example.com/logo.png" property="og:image">`,
+	)
+}
+
+func TestEmbedUnavailable(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.UnavailableCacheTTL = time.Minute
+	})
+	const gone = "00000000-0000-4000-8000-000000000000"
+	fixtures.handle("/manga/"+gone, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusGone)
+	})
+
+	for _, id := range []string{"0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d", gone} {
+		w := get(r, "/title/"+id)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("status of %s = %d, want 404", id, w.Code)
+		}
+		if cc := w.Header().Get("Cache-Control"); cc != "public, max-age=60" {
+			t.Errorf("Cache-Control of %s = %q, want public, max-age=60", id, cc)
+		}
+		assertContains(t, w.Body.String(),
+			`<meta content="This title is no longer available" property="og:title">`,
+			`url='https://mangadex.org/title/`+id+`'`,
+		)
+	}
+
+	// Upstream failures are errors, not removed manga
+	fixtures.handle("/manga/"+gone, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	w := get(r, "/title/"+gone)
+	if w.Code == http.StatusNotFound || strings.Contains(w.Body.String(), config.UnavailableMessage) {
+		t.Errorf("upstream failure = %d with the unavailable embed", w.Code)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"encoding/json"
+	"html/template"
+
+	"github.com/gin-gonic/gin"
+)
+
+type jsonLDPerson struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+// jsonLDBook is the manga as schema.org Book, see https://schema.org/Book
+type jsonLDBook struct {
+	Context       string         `json:"@context"`
+	Type          string         `json:"@type"`
+	Name          string         `json:"name"`
+	AlternateName string         `json:"alternateName,omitempty"`
+	Description   string         `json:"description,omitempty"`
+	Image         string         `json:"image,omitempty"`
+	Url           string         `json:"url"`
+	Author        []jsonLDPerson `json:"author,omitempty"`
+	Keywords      string         `json:"keywords,omitempty"`
+	InLanguage    string         `json:"inLanguage,omitempty"`
+	DatePublished string         `json:"datePublished,omitempty"`
+}
+
+// embedJSONLD returns the JSON-LD structured data of the embed data. The json
+// encoder escapes <, > and &, so it can be put in a script element as is.
+func embedJSONLD(data gin.H) template.JS {
+	str := func(key string) string {
+		s, _ := data[key].(string)
+		return s
+	}
+
+	book := jsonLDBook{
+		Context:       "https://schema.org",
+		Type:          "Book",
+		Name:          str("title"),
+		AlternateName: str("subtitle"),
+		Description:   str("og_content"),
+		Image:         str("og_image"),
+		Url:           str("og_name"),
+		Keywords:      str("tags"),
+		InLanguage:    str("original_language"),
+		DatePublished: str("year"),
+	}
+
+	authors, _ := data["authors"].([]string)
+	for _, name := range authors {
+		book.Author = append(book.Author, jsonLDPerson{Type: "Person", Name: name})
+	}
+
+	b, err := json.Marshal(book)
+	if err != nil {
+		return ""
+	}
+	return template.JS(b)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var jsonLDScript = regexp.MustCompile(`(?s)<script type="application/ld\+json">(.*?)</script>`)
+
+func TestEmbedJSONLD(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	body := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d").Body.String()
+	m := jsonLDScript.FindStringSubmatch(body)
+	if m == nil {
+		t.Fatalf("embed has no JSON-LD:\n%s", body)
+	}
+
+	var book map[string]interface{}
+	if err := json.Unmarshal([]byte(m[1]), &book); err != nil {
+		t.Fatalf("JSON-LD is not valid JSON: %v\n%s", err, m[1])
+	}
+
+	want := map[string]interface{}{
+		"@context":      "https://schema.org",
+		"@type":         "Book",
+		"name":          "Yotsuba&!",
+		"alternateName": "よつばと！",
+		"image":         fixtures.URL + "/covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg",
+		"url":           "https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"author":        []interface{}{map[string]interface{}{"@type": "Person", "name": "Azuma Kiyohiko"}},
+		"inLanguage":    "ja",
+		"datePublished": "2003",
+	}
+	for key, v := range want {
+		if !reflect.DeepEqual(book[key], v) {
+			t.Errorf("JSON-LD %s = %#v, want %#v", key, book[key], v)
+		}
+	}
+	if d, _ := book["description"].(string); !strings.HasPrefix(d, "Yotsuba is a strange little girl") {
+		t.Errorf("JSON-LD description = %q", d)
+	}
+
+	// Error embeds have no structured data
+	if body := get(r, "/title/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d").Body.String(); jsonLDScript.MatchString(body) {
+		t.Errorf("embed of a missing manga has JSON-LD:\n%s", body)
+	}
+}
+
+func TestEmbedJSONLDEscapes(t *testing.T) {
+	js := string(embedJSONLD(gin.H{"title": "</script><script>alert(1)</script>"}))
+	if strings.Contains(js, "<") || strings.Contains(js, ">") {
+		t.Errorf("embedJSONLD does not escape the script element: %s", js)
+	}
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/valyala/fastjson"
+)
+
+// parseAcceptLanguage returns the languages of an Accept-Language header,
+// lowercased and ordered by their quality value.
+func parseAcceptLanguage(header string) []string {
+	type weighted struct {
+		lang string
+		q    float64
+	}
+
+	var langs []weighted
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		lang := strings.ToLower(strings.TrimSpace(fields[0]))
+		if lang == "" || lang == "*" {
+			continue
+		}
+
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+
+		if q > 0 {
+			langs = append(langs, weighted{lang, q})
+		}
+	}
+
+	sort.SliceStable(langs, func(i, j int) bool {
+		return langs[i].q > langs[j].q
+	})
+
+	result := make([]string, len(langs))
+	for i, l := range langs {
+		result[i] = l.lang
+	}
+	return result
+}
+
+// requestLanguages returns the languages preferred by the request, first the
+// comma separated ?lang= parameter and then the Accept-Language header.
+// Only the response depends on the header, the cached MangaDex responses
+// are the same for all languages.
+func requestLanguages(c *gin.Context) []string {
+	if config.VaryLanguage && !varies(c, "Accept-Language") {
+		c.Writer.Header().Add("Vary", "Accept-Language")
+	}
+
+	var langs []string
+	for _, lang := range strings.Split(c.Query("lang"), ",") {
+		if lang = strings.ToLower(strings.TrimSpace(lang)); lang != "" {
+			langs = append(langs, lang)
+		}
+	}
+	return append(langs, parseAcceptLanguage(c.GetHeader("Accept-Language"))...)
+}
+
+// varies reports whether the response already varies by the header.
+func varies(c *gin.Context, header string) bool {
+	for _, v := range c.Writer.Header().Values("Vary") {
+		for _, h := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(h), header) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// localizedString returns the value of a language of a localized object, or
+// an empty string, so the language is skipped, if it is not a string. Null
+// values are skipped without a warning.
+func localizedString(lang []byte, v *fastjson.Value) string {
+	if v.Type() != fastjson.TypeString {
+		if v.Type() != fastjson.TypeNull {
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: value of language %q has type %s instead of string\n", lang, v.Type())
+		}
+		return ""
+	}
+	return string(v.GetStringBytes())
+}
+
+// matchLanguage returns the value and language of the first language in langs
+// present in obj, falling back to a language with the same primary subtag,
+// e.g. "pt" for "pt-br". Both are empty if none of langs is present.
+func matchLanguage(obj *fastjson.Object, langs []string) (string, string) {
+	if obj == nil {
+		return "", ""
+	}
+
+	for _, lang := range langs {
+		if v := obj.Get(lang); v != nil {
+			if s := localizedString([]byte(lang), v); s != "" {
+				return s, lang
+			}
+		}
+
+		primary := strings.SplitN(lang, "-", 2)[0]
+		var match, matchLang string
+		obj.Visit(func(key []byte, v *fastjson.Value) {
+			if match == "" && strings.SplitN(string(key), "-", 2)[0] == primary {
+				match, matchLang = localizedString(key, v), string(key)
+			}
+		})
+		if match != "" {
+			return match, matchLang
+		}
+	}
+
+	return "", ""
+}
+
+// localize picks the value of a localized object such as the title or
+// description, in the following order:
+//
+//  1. the first available language of langs
+//  2. english
+//  3. the first language of the object with a value
+//
+// It returns the value and its language, both empty if obj has no values.
+func localize(obj *fastjson.Object, langs []string) (string, string) {
+	if value, lang := matchLanguage(obj, append(append([]string{}, langs...), "en")); lang != "" {
+		return value, lang
+	}
+
+	var value, lang string
+	obj.Visit(func(key []byte, v *fastjson.Value) {
+		if value != "" {
+			return
+		}
+		if s := localizedString(key, v); s != "" {
+			value, lang = s, string(key)
+		}
+	})
+	return value, lang
+}
+
+// localizeDescription picks the description like localize, except that
+// with DESCRIPTION_TIEBREAK=longest the longest description is taken when
+// none of langs or english has one.
+func localizeDescription(obj *fastjson.Object, langs []string) (string, string) {
+	if config.DescriptionTiebreak != "longest" {
+		return localize(obj, langs)
+	}
+	if value, lang := matchLanguage(obj, append(append([]string{}, langs...), "en")); lang != "" {
+		return value, lang
+	}
+
+	// Of equally long descriptions the first one is taken
+	var value, lang string
+	longest := 0
+	obj.Visit(func(key []byte, v *fastjson.Value) {
+		s := localizedString(key, v)
+		if n := utf8.RuneCountInString(s); n > longest {
+			value, lang, longest = s, string(key), n
+		}
+	})
+	return value, lang
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/valyala/fastjson"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{"", []string{}},
+		{"fr", []string{"fr"}},
+		{"fr-CH, fr;q=0.9, en;q=0.8, de;q=0.7, *;q=0.5", []string{"fr-ch", "fr", "en", "de"}},
+		{"en;q=0.5, ja, pt-BR;q=0.8", []string{"ja", "pt-br", "en"}},
+		{"de;q=0.7, es;q=0.7, it", []string{"it", "de", "es"}},
+		{"en;q=0, ja", []string{"ja"}},
+		{"ja;q=abc", []string{"ja"}},
+		{" , ;q=0.5", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseAcceptLanguage(%q) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestRequestLanguages(t *testing.T) {
+	config = loadConfig()
+	gin.SetMode(gin.TestMode)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d?lang=ES,%20pt-BR", nil)
+	c.Request.Header.Set("Accept-Language", "de;q=0.5, fr")
+
+	want := []string{"es", "pt-br", "fr", "de"}
+	if got := requestLanguages(c); !reflect.DeepEqual(got, want) {
+		t.Errorf("requestLanguages = %v, want %v", got, want)
+	}
+}
+
+func TestEmbedDescriptionLanguage(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	const path = "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	w := get(r, path, "Accept-Language", "de, fr;q=0.9, en;q=0.8")
+	assertContains(t, w.Body.String(), `Yotsuba est une petite fille étrange." property="og:description"`)
+
+	// The query takes precedence over the header
+	w = get(r, path+"?lang=en", "Accept-Language", "fr")
+	assertContains(t, w.Body.String(), `Yotsuba is a strange little girl with a big heart." property="og:description"`)
+
+	w = get(r, path, "Accept-Language", "de")
+	assertContains(t, w.Body.String(), `Yotsuba is a strange little girl with a big heart." property="og:description"`)
+}
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		name  string
+		obj   string
+		langs []string
+		value string
+		lang  string
+	}{
+		{"requested", `{"en": "English", "fr": "Français"}`, []string{"fr"}, "Français", "fr"},
+		{"first requested", `{"en": "English", "fr": "Français", "de": "Deutsch"}`, []string{"es", "de", "fr"}, "Deutsch", "de"},
+		{"primary subtag", `{"en": "English", "pt-br": "Português"}`, []string{"pt"}, "Português", "pt-br"},
+		{"region of the request", `{"en": "English", "pt": "Português"}`, []string{"pt-br"}, "Português", "pt"},
+		{"english without requested", `{"ja": "日本語", "en": "English"}`, []string{"fr"}, "English", "en"},
+		{"english without any requested", `{"ja": "日本語", "en": "English"}`, nil, "English", "en"},
+		{"first without english", `{"ja": "日本語", "ko": "한국어"}`, []string{"fr"}, "日本語", "ja"},
+		{"empty requested", `{"fr": "", "en": "English"}`, []string{"fr"}, "English", "en"},
+		{"empty english", `{"en": "", "ja": "日本語"}`, nil, "日本語", "ja"},
+		{"null values", `{"en": null, "fr": null, "ja": "日本語"}`, []string{"fr"}, "日本語", "ja"},
+		{"non string values", `{"en": 5, "ja": ["日本語"], "ko": "한국어"}`, nil, "한국어", "ko"},
+		{"only empty values", `{"en": "", "ja": ""}`, []string{"ja"}, "", ""},
+		{"no values", `{}`, []string{"en"}, "", ""},
+	}
+
+	for _, tt := range tests {
+		value, lang := localize(fastjson.MustParse(tt.obj).GetObject(), tt.langs)
+		if value != tt.value || lang != tt.lang {
+			t.Errorf("%s: localize(%s, %v) = %q, %q, want %q, %q", tt.name, tt.obj, tt.langs, value, lang, tt.value, tt.lang)
+		}
+	}
+
+	if value, lang := localize(nil, []string{"en"}); value != "" || lang != "" {
+		t.Errorf("localize of no object = %q, %q, want nothing", value, lang)
+	}
+}
+
+func TestLocalizeStable(t *testing.T) {
+	tests := []struct {
+		obj   string
+		langs []string
+		value string
+	}{
+		{`{"ja": "日本語", "ko": "한국어", "zh": "中文", "fr": "Français", "de": "Deutsch"}`, []string{"es"}, "日本語"},
+		{`{"pt": "Português", "pt-br": "Português do Brasil", "es": "Español"}`, []string{"pt-pt"}, "Português"},
+		{`{"es-la": "Latino", "es": "Español"}`, []string{"es-mx"}, "Latino"},
+	}
+
+	for _, tt := range tests {
+		for i := 0; i < 100; i++ {
+			if value, _ := localize(fastjson.MustParse(tt.obj).GetObject(), tt.langs); value != tt.value {
+				t.Fatalf("localize(%s, %v) = %q in run %d, want %q", tt.obj, tt.langs, value, i, tt.value)
+			}
+		}
+	}
+}
+
+func TestEmbedLanguageStable(t *testing.T) {
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither the title nor the description is in english
+	fixture = bytes.Replace(fixture, []byte(`"title": {"en": "Yotsuba&!"}`), []byte(`"title": {"ko": "요츠바랑!", "ja-ro": "Yotsubato!", "zh": "四叶妹妹！"}`), 1)
+	fixture = bytes.Replace(fixture, []byte(`"description": {"en": "Yotsuba is a strange little girl with a big heart.", `), []byte(`"description": {"de": "Yotsuba ist ein seltsames kleines Mädchen.", "it": "Yotsuba è una strana bambina.", `), 1)
+
+	r, fixtures := newTestService(t, nil)
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(fixture)
+	})
+
+	first := get(r, "/api/title/"+mangaId+"?fields=title,og_content").Body.String()
+	assertContains(t, first, `"og_content":"Yotsuba ist ein seltsames kleines Mädchen."`, `"title":"요츠바랑!"`)
+	for i := 0; i < 50; i++ {
+		if got := get(r, "/api/title/"+mangaId+"?fields=title,og_content").Body.String(); got != first {
+			t.Fatalf("embed %d = %s, want %s", i, got, first)
+		}
+	}
+}
+
+func TestVaries(t *testing.T) {
+	tests := []struct {
+		vary []string
+		want bool
+	}{
+		{nil, false},
+		{[]string{"Accept"}, false},
+		{[]string{"Accept-Language"}, true},
+		{[]string{"Accept, accept-language"}, true},
+		{[]string{"Accept", "Accept-Language"}, true},
+		{[]string{"Accept-Encoding"}, false},
+	}
+
+	for _, tt := range tests {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		for _, v := range tt.vary {
+			c.Writer.Header().Add("Vary", v)
+		}
+		if got := varies(c, "Accept-Language"); got != tt.want {
+			t.Errorf("varies(%q, Accept-Language) = %v, want %v", tt.vary, got, tt.want)
+		}
+	}
+}
+
+func TestVaryLanguage(t *testing.T) {
+	const path = "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.CacheTTL = time.Minute
+	})
+
+	fr := get(r, path, "Accept-Language", "fr")
+	en := get(r, path, "Accept-Language", "en")
+	for _, w := range []*httptest.ResponseRecorder{fr, en} {
+		if vary := w.Header().Values("Vary"); !reflect.DeepEqual(vary, []string{"Accept", "Accept-Language"}) {
+			t.Errorf("Vary = %q, want Accept and Accept-Language", vary)
+		}
+	}
+	assertContains(t, fr.Body.String(), "Yotsuba est une petite fille étrange.")
+	assertContains(t, en.Body.String(), "Yotsuba is a strange little girl with a big heart.")
+	if fr.Header().Get("ETag") == en.Header().Get("ETag") {
+		t.Errorf("both languages have the ETag %q", fr.Header().Get("ETag"))
+	}
+
+	// The MangaDex response is the same for both and cached once
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); n != 1 {
+		t.Errorf("manga requested %d times, want 1", n)
+	}
+
+	r, _ = newTestService(t, func(c *Config) {
+		c.VaryLanguage = false
+	})
+	if vary := get(r, path, "Accept-Language", "fr").Header().Values("Vary"); !reflect.DeepEqual(vary, []string{"Accept"}) {
+		t.Errorf("Vary with VARY_LANGUAGE=false = %q, want Accept", vary)
+	}
+}
+
+func TestLocalizeDescriptionLongest(t *testing.T) {
+	config = loadConfig()
+	obj := `{"de": "Kurz.", "fr": "Une description plus longue.", "it": "Media lunghezza.", "ja": "", "ko": null}`
+
+	tests := []struct {
+		tiebreak string
+		obj      string
+		langs    []string
+		value    string
+		lang     string
+	}{
+		{"longest", obj, nil, "Une description plus longue.", "fr"},
+		{"longest", obj, []string{"es"}, "Une description plus longue.", "fr"},
+		{"first", obj, nil, "Kurz.", "de"},
+		// A requested language or english still goes first
+		{"longest", obj, []string{"de"}, "Kurz.", "de"},
+		{"longest", `{"ja": "とても長い説明文です。", "en": "Short."}`, nil, "Short.", "en"},
+		// Characters are counted, not bytes
+		{"longest", `{"ja": "日本語の説明", "ko": "A longer one"}`, nil, "A longer one", "ko"},
+		// Of equally long descriptions the first one
+		{"longest", `{"pt": "Igual", "es": "Igual"}`, nil, "Igual", "pt"},
+		{"longest", `{"ja": "", "ko": null}`, nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		config.DescriptionTiebreak = tt.tiebreak
+		for i := 0; i < 20; i++ {
+			value, lang := localizeDescription(fastjson.MustParse(tt.obj).GetObject(), tt.langs)
+			if value != tt.value || lang != tt.lang {
+				t.Fatalf("localizeDescription(%s, %v) with %s = %q, %q, want %q, %q", tt.obj, tt.langs, tt.tiebreak, value, lang, tt.value, tt.lang)
+			}
+		}
+	}
+}
+
+func TestEmbedLongestDescription(t *testing.T) {
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Neither description is in english, the longer one comes last
+	fixture = bytes.Replace(fixture, []byte(`"description": {"en": "Yotsuba is a strange little girl with a big heart.", "fr": "Yotsuba est une petite fille étrange."}`),
+		[]byte(`"description": {"fr": "Yotsuba est une petite fille étrange.", "it": "Yotsuba è una bambina strana con un grande cuore."}`), 1)
+
+	tests := []struct {
+		tiebreak string
+		want     string
+	}{
+		{"longest", `Yotsuba è una bambina strana con un grande cuore." property="og:description"`},
+		{"first", `Yotsuba est une petite fille étrange." property="og:description"`},
+	}
+
+	for _, tt := range tests {
+		r, fixtures := newTestService(t, func(c *Config) {
+			c.DescriptionTiebreak = tt.tiebreak
+		})
+		fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(fixture)
+		})
+
+		assertContains(t, get(r, "/title/"+mangaId, "Accept-Language", "de").Body.String(), tt.want)
+	}
+}
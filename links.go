@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/valyala/fastjson"
+)
+
+// linkFormats maps the short keys of the manga `links` attribute to full urls,
+// keys missing here already contain a full url.
+// See https://api.mangadex.org/docs/3-enumerations/#manga-links-data
+var linkFormats = map[string]string{
+	"al":  "https://anilist.co/manga/%s",
+	"ap":  "https://www.anime-planet.com/manga/%s",
+	"bw":  "https://bookwalker.jp/%s",
+	"mu":  "https://www.mangaupdates.com/series.html?id=%s",
+	"nu":  "https://www.novelupdates.com/series/%s",
+	"mal": "https://myanimelist.net/manga/%s",
+}
+
+var numeric = regexp.MustCompile(`^[0-9]+$`)
+
+// linkUrl returns the full url of the link with the given key.
+func linkUrl(key string, value string) string {
+	if key == "kt" {
+		// Kitsu links are either a numeric id or a slug
+		if numeric.MatchString(value) {
+			return fmt.Sprintf("https://kitsu.io/manga/%s", value)
+		}
+		return fmt.Sprintf("https://kitsu.io/api/edge/manga?filter[slug]=%s", value)
+	}
+
+	if format, ok := linkFormats[key]; ok {
+		return fmt.Sprintf(format, value)
+	}
+	return value
+}
+
+// parseLinks returns at most `max` urls from the links object,
+// picked in the order of `keys`.
+func parseLinks(links *fastjson.Object, keys []string, max int) []string {
+	if links == nil {
+		return nil
+	}
+
+	var urls []string
+	for _, key := range keys {
+		if len(urls) >= max {
+			break
+		}
+
+		value := string(links.Get(key).GetStringBytes())
+		if value == "" {
+			continue
+		}
+		urls = append(urls, linkUrl(key, value))
+	}
+
+	return urls
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+const linksFixture = `{
+	"al": "30013",
+	"ap": "one-piece",
+	"bw": "series/60293",
+	"kt": "one-piece",
+	"mu": "ko0ydb9",
+	"mal": "13",
+	"raw": "https://www.shonenjump.com/j/rensai/onepiece.html",
+	"engtl": "https://www.viz.com/shonenjump/chapters/one-piece"
+}`
+
+func TestLinkUrl(t *testing.T) {
+	tests := []struct {
+		key, value, want string
+	}{
+		{"al", "30013", "https://anilist.co/manga/30013"},
+		{"ap", "one-piece", "https://www.anime-planet.com/manga/one-piece"},
+		{"bw", "series/60293", "https://bookwalker.jp/series/60293"},
+		{"mu", "ko0ydb9", "https://www.mangaupdates.com/series.html?id=ko0ydb9"},
+		{"nu", "solo-leveling", "https://www.novelupdates.com/series/solo-leveling"},
+		{"mal", "13", "https://myanimelist.net/manga/13"},
+		{"kt", "21", "https://kitsu.io/manga/21"},
+		{"kt", "one-piece", "https://kitsu.io/api/edge/manga?filter[slug]=one-piece"},
+		{"raw", "https://www.shonenjump.com/", "https://www.shonenjump.com/"},
+	}
+
+	for _, tt := range tests {
+		if got := linkUrl(tt.key, tt.value); got != tt.want {
+			t.Errorf("linkUrl(%q, %q) = %q, want %q", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseLinks(t *testing.T) {
+	links := fastjson.MustParse(linksFixture).GetObject()
+
+	tests := []struct {
+		keys []string
+		max  int
+		want []string
+	}{
+		{
+			keys: []string{"engtl", "raw", "al", "mal"},
+			max:  2,
+			want: []string{"https://www.viz.com/shonenjump/chapters/one-piece", "https://www.shonenjump.com/j/rensai/onepiece.html"},
+		},
+		{
+			keys: []string{"cdj", "al", "mal"},
+			max:  5,
+			want: []string{"https://anilist.co/manga/30013", "https://myanimelist.net/manga/13"},
+		},
+		{
+			keys: []string{"al"},
+			max:  0,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := parseLinks(links, tt.keys, tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("parseLinks(%v, %d) = %v, want %v", tt.keys, tt.max, got, tt.want)
+		}
+	}
+
+	if got := parseLinks(nil, []string{"al"}, 2); got != nil {
+		t.Errorf("parseLinks of no links = %v, want none", got)
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// defaultLocales maps MangaDex languages without a region to the locale
+// of their main territory, OpenGraph requires both parts.
+var defaultLocales = map[string]string{
+	"ar":    "ar_AR",
+	"bg":    "bg_BG",
+	"cs":    "cs_CZ",
+	"da":    "da_DK",
+	"de":    "de_DE",
+	"el":    "el_GR",
+	"en":    "en_US",
+	"es":    "es_ES",
+	"es-la": "es_LA",
+	"fa":    "fa_IR",
+	"fi":    "fi_FI",
+	"fr":    "fr_FR",
+	"he":    "he_IL",
+	"hi":    "hi_IN",
+	"hu":    "hu_HU",
+	"id":    "id_ID",
+	"it":    "it_IT",
+	"ja":    "ja_JP",
+	"ko":    "ko_KR",
+	"ms":    "ms_MY",
+	"nl":    "nl_NL",
+	"no":    "nb_NO",
+	"pl":    "pl_PL",
+	"pt":    "pt_PT",
+	"ro":    "ro_RO",
+	"ru":    "ru_RU",
+	"sv":    "sv_SE",
+	"th":    "th_TH",
+	"tl":    "tl_PH",
+	"tr":    "tr_TR",
+	"uk":    "uk_UA",
+	"vi":    "vi_VN",
+	"zh":    "zh_CN",
+}
+
+// flagCountries maps the common original languages of manga to the country
+// whose flag stands for them.
+var flagCountries = map[string]string{
+	"ja":    "JP",
+	"ko":    "KR",
+	"zh":    "CN",
+	"zh-hk": "HK",
+}
+
+// languageFlag returns the flag emoji of the original language lang, or an
+// empty string for other languages. A flag is two regional indicator
+// symbols, which are four bytes each in UTF-8.
+func languageFlag(lang string) string {
+	country, ok := flagCountries[strings.ToLower(lang)]
+	if !ok {
+		return ""
+	}
+
+	var b strings.Builder
+	for _, c := range country {
+		b.WriteRune(0x1F1E6 + c - 'A')
+	}
+	return b.String()
+}
+
+// ogLocale converts a MangaDex language code to an OpenGraph locale, e.g.
+// "pt-br" to "pt_BR". Romanizations such as "ja-ro" and unknown languages
+// without a region have no locale and return an empty string.
+func ogLocale(lang string, overrides map[string]string) string {
+	lang = strings.ToLower(lang)
+	if l, ok := overrides[lang]; ok {
+		return l
+	}
+	if l, ok := defaultLocales[lang]; ok {
+		return l
+	}
+
+	parts := strings.SplitN(lang, "-", 2)
+	if len(parts) != 2 || len(parts[1]) != 2 || parts[1] == "ro" {
+		return ""
+	}
+	return parts[0] + "_" + strings.ToUpper(parts[1])
+}
+
+// embedLocales returns the locale of lang and the distinct locales of the
+// other languages in objs.
+func embedLocales(lang string, objs ...*fastjson.Object) (string, []string) {
+	locale := ogLocale(lang, config.Locales)
+
+	var alternates []string
+	seen := map[string]bool{locale: true, "": true}
+	for _, obj := range objs {
+		obj.Visit(func(key []byte, v *fastjson.Value) {
+			l := ogLocale(string(key), config.Locales)
+			if !seen[l] {
+				seen[l] = true
+				alternates = append(alternates, l)
+			}
+		})
+	}
+	return locale, alternates
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestOgLocale(t *testing.T) {
+	overrides := map[string]string{"es-la": "es_MX"}
+
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"en", "en_US"},
+		{"ja", "ja_JP"},
+		{"no", "nb_NO"},
+		{"pt-br", "pt_BR"},
+		{"PT-BR", "pt_BR"},
+		{"zh-hk", "zh_HK"},
+		{"es-la", "es_MX"},
+		{"ja-ro", ""},
+		{"ko-ro", ""},
+		{"eo", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := ogLocale(tt.lang, overrides); got != tt.want {
+			t.Errorf("ogLocale(%q) = %q, want %q", tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedLocales(t *testing.T) {
+	newTestService(t, nil)
+
+	title := fastjson.MustParse(`{"ja-ro": "Yotsubato!", "en": "Yotsuba&!"}`).GetObject()
+	description := fastjson.MustParse(`{"en": "...", "fr": "...", "pt-br": "...", "eo": "..."}`).GetObject()
+
+	locale, alternates := embedLocales("en", title, description)
+	if locale != "en_US" {
+		t.Errorf("locale = %q, want en_US", locale)
+	}
+	if want := []string{"fr_FR", "pt_BR"}; !reflect.DeepEqual(alternates, want) {
+		t.Errorf("alternates = %q, want %q", alternates, want)
+	}
+}
+
+func TestEmbedLocaleTags(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	body := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d").Body.String()
+	assertContains(t, body,
+		`<meta content="en_US" property="og:locale">`,
+		`<meta content="fr_FR" property="og:locale:alternate">`,
+	)
+	if strings.Contains(body, `<meta content="en_US" property="og:locale:alternate">`) {
+		t.Error("the locale of the embed is also an alternate")
+	}
+
+	body = get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "Accept-Language", "fr").Body.String()
+	assertContains(t, body,
+		`<meta content="fr_FR" property="og:locale">`,
+		`<meta content="en_US" property="og:locale:alternate">`,
+	)
+}
+
+func TestLanguageFlag(t *testing.T) {
+	tests := []struct {
+		lang string
+		want string
+	}{
+		{"ja", "🇯🇵"},
+		{"ko", "🇰🇷"},
+		{"zh", "🇨🇳"},
+		{"zh-hk", "🇭🇰"},
+		{"JA", "🇯🇵"},
+		{"ja-ro", ""},
+		{"en", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		got := languageFlag(tt.lang)
+		if got != tt.want {
+			t.Errorf("languageFlag(%q) = %q, want %q", tt.lang, got, tt.want)
+		}
+		if got != "" && (!utf8.ValidString(got) || len(got) != 8 || utf8.RuneCountInString(got) != 2) {
+			t.Errorf("languageFlag(%q) = %q is not two regional indicators", tt.lang, got)
+		}
+	}
+}
+
+func TestEmbedTitleFlag(t *testing.T) {
+	tests := []struct {
+		flag bool
+		id   string
+		want string
+	}{
+		{true, "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", `<meta content="🇯🇵 Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`},
+		{true, "5e7a9c1b-3d5f-4b7a-9c1e-3f5a7c9e1b3d", `<meta content="🇰🇷 The Tower Climber`},
+		{false, "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`},
+	}
+
+	for _, tt := range tests {
+		r, _ := newTestService(t, func(c *Config) {
+			c.TitleFlag = tt.flag
+		})
+		assertContains(t, get(r, "/title/"+tt.id).Body.String(), tt.want)
+
+		// The plain title has no flag
+		if got := get(r, "/title/"+tt.id, "Accept", "text/plain").Body.String(); strings.ContainsAny(got, "🇯🇵🇰🇷") {
+			t.Errorf("plain title of %s with TITLE_FLAG=%v = %q, want no flag", tt.id, tt.flag, got)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"io"
+	"os"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+// logWriter returns the writer for the logs, depending on the configured output.
+// The returned closer flushes and closes the log file, if any.
+func logWriter(cfg *Config) (io.Writer, io.Closer) {
+	if cfg.LogOutput == "stdout" {
+		return os.Stdout, io.NopCloser(nil)
+	}
+
+	// The file is rotated once it reaches LogMaxSize megabytes
+	f := &lumberjack.Logger{
+		Filename:   cfg.LogFile,
+		MaxSize:    cfg.LogMaxSize,
+		MaxAge:     cfg.LogMaxAge,
+		MaxBackups: cfg.LogMaxBackups,
+	}
+	return io.MultiWriter(f, os.Stdout), f
+}
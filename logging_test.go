@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+)
+
+func TestLogWriterStdout(t *testing.T) {
+	w, closer := logWriter(&Config{LogOutput: "stdout", LogFile: filepath.Join(t.TempDir(), "gin.log")})
+	if w != os.Stdout {
+		t.Errorf("writer = %v, want stdout", w)
+	}
+	if err := closer.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestLogWriterRotates(t *testing.T) {
+	dir := t.TempDir()
+	_, closer := logWriter(&Config{
+		LogOutput:     "file",
+		LogFile:       filepath.Join(dir, "gin.log"),
+		LogMaxSize:    1,
+		LogMaxBackups: 2,
+	})
+	defer closer.Close()
+
+	// The file is written to directly, the writer also copies to stdout
+	f, ok := closer.(*lumberjack.Logger)
+	if !ok {
+		t.Fatalf("closer is a %T, want the log file", closer)
+	}
+	line := append(bytes.Repeat([]byte("a"), 1023), '\n')
+	for i := 0; i < 1025; i++ {
+		if _, err := f.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := closer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Errorf("log directory has %d files, want the log and one backup", len(files))
+	}
+}
@@ -2,39 +2,131 @@ package main
 
 import (
 	"context"
+	"errors"
+	"expvar"
+	"flag"
 	"fmt"
+	"html/template"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/valyala/fastjson"
+	"golang.org/x/sync/errgroup"
+	"golang.org/x/sync/singleflight"
 	"golang.org/x/time/rate"
 )
 
 const (
-	mangaEndpoint  = "https://api.mangadex.org/manga/%s"
-	authorEndpoint = "https://api.mangadex.org/author/%s"
-	coverEndpoint  = "https://api.mangadex.org/cover/%s"
+	// Endpoints are relative to the API url of the client
+	mangaEndpoint  = "/manga/%s?includes[]=cover_art"
+	authorEndpoint = "/author/%s"
+	coverEndpoint  = "/cover/%s"
 
-	CoverUri = "https://uploads.mangadex.org/covers/%s/%s"
+	chapterEndpoint = "/chapter/%s"
+
+	// Relative to the uploads url
+	CoverUri = "/covers/%s/%s"
 )
 
 var dexClient *RateLimitedClient
-var parser fastjson.Parser
 
 type RateLimitedClient struct {
 	client      *http.Client
 	Ratelimiter *rate.Limiter
+	clock       Clock
+
+	// Number of requests currently waiting on the rate limiter
+	waiting int64
+
+	// Cache of response bodies by url, nil if responses are not cached
+	cache *Cache
+	// Urls whose response could not be parsed, these are not requested again
+	// until the entry expires. Nil if malformed responses are not cached
+	malformed *Cache
+
+	// Limits on concurrent requests by endpoint, endpoints without one are not limited
+	budgets map[string]semaphore
+	// Circuit breakers by base url and endpoint, endpoints without one are
+	// always requested
+	breakers *breakerSet
+	// Deduplicates concurrent fetches by url
+	flight singleflight.Group
+	// Contexts of the fetches in flight by url, cancelled once no caller
+	// waits for them anymore
+	fetchMu sync.Mutex
+	fetches map[string]*sharedFetch
+
+	// Guards the settings below, which can change on reload
+	mu sync.RWMutex
+
+	// Maximum number of waiting requests before new ones are shed, 0 for no limit
+	MaxWaiting int64
+
+	// Base url of the API the endpoints are requested from, and of the
+	// mirrors tried in order when it fails. Every base but the last gets
+	// mirrorTimeout to respond
+	apiUrl        string
+	mirrors       []string
+	mirrorTimeout time.Duration
+
+	// Adapt the limiter to the remaining quota reported by MangaDex,
+	// between FloorRate and BaseRate once at most LowQuota requests remain
+	Adaptive  bool
+	BaseRate  rate.Limit
+	FloorRate rate.Limit
+	LowQuota  int
+}
+
+// wait blocks until the rate limiter allows a request,
+// using the client's clock instead of the limiter's own.
+func (c *RateLimitedClient) wait(ctx context.Context) error {
+	r := c.Ratelimiter.ReserveN(c.clock.Now(), 1)
+	if !r.OK() {
+		return fmt.Errorf("rate limiter burst exceeded")
+	}
+
+	delay := r.DelayFrom(c.clock.Now())
+	if delay == 0 {
+		return nil
+	}
+
+	select {
+	case <-c.clock.After(delay):
+		return nil
+	case <-ctx.Done():
+		r.CancelAt(c.clock.Now())
+		return ctx.Err()
+	}
 }
 
 func (c *RateLimitedClient) Do(req *http.Request) (*http.Response, error) {
-	ctx := context.Background()
-	err := c.Ratelimiter.Wait(ctx)
+	c.mu.RLock()
+	maxWaiting := c.MaxWaiting
+	c.mu.RUnlock()
+
+	waiting := atomic.AddInt64(&c.waiting, 1)
+	if maxWaiting > 0 && waiting > maxWaiting {
+		atomic.AddInt64(&c.waiting, -1)
+		limiterErrors.Add(1)
+		return nil, &LimiterError{Err: ErrOverloaded}
+	}
+
+	err := c.wait(req.Context())
+	atomic.AddInt64(&c.waiting, -1)
 	if err != nil {
-		return nil, err
+		limiterErrors.Add(1)
+		return nil, &LimiterError{Err: err}
 	}
 	resp, err := c.client.Do(req)
 	if err != nil {
@@ -43,159 +135,1068 @@ func (c *RateLimitedClient) Do(req *http.Request) (*http.Response, error) {
 	return resp, nil
 }
 
-func (c *RateLimitedClient) RequestJSON(endpoint string, id string) (*fastjson.Value, error) {
-	request, _ := http.NewRequest("GET", fmt.Sprintf(endpoint, id), nil)
+func (c *RateLimitedClient) RequestJSON(ctx context.Context, endpoint string, id string) (*fastjson.Value, error) {
+	// Responses are cached by the url of the main API whichever base they
+	// were fetched from
+	path := fmt.Sprintf(endpoint, id)
+	bases := c.bases()
+	url := bases[0] + path
+
+	var err error
+	var bytes []byte
+	cached := false
+	if c.cache != nil {
+		bytes, cached = c.cache.Get(url)
+	}
+	if c.malformed != nil && !cached {
+		if _, bad := c.malformed.Get(url); bad {
+			return nil, &ParseError{Url: url, Err: ErrMalformed}
+		}
+	}
+
+	if !cached && !takeCall(ctx) {
+		callBudgetExceeded.Add(1)
+		return nil, ErrCallBudget
+	}
+
+	if !cached {
+		// Concurrent requests of the same url, e.g. the author of several
+		// manga, share a single fetch. It does not stop when the first of
+		// them gives up, the others may still be waiting for it, only once
+		// all of them did. It may retry as often as the budget of the first
+		// allows, and every caller is charged for the retries it made
+		retries := retriesLeft(ctx)
+		fetch := c.joinFetch(url)
+		ch := c.flight.DoChan(url, func() (interface{}, error) {
+			fetchCtx := fetch.ctx
+			sem := c.budgets[endpoint]
+			if err := sem.acquire(fetchCtx); err != nil {
+				return fetchResult{}, fmt.Errorf("could not complete manga request: %w", err)
+			}
+			defer sem.release()
+			return c.fetchFailover(fetchCtx, endpoint, path, bases, retries)
+		})
+
+		select {
+		case res := <-ch:
+			c.leaveFetch(url, fetch)
+			result, _ := res.Val.(fetchResult)
+			chargeRetries(ctx, result.retries)
+			if res.Err != nil {
+				return nil, res.Err
+			}
+			bytes = result.body
+		case <-ctx.Done():
+			c.leaveFetch(url, fetch)
+			return nil, fmt.Errorf("could not complete manga request: %w", ctx.Err())
+		}
+	}
+
+	// A fresh parser is used for every response, as values from a shared
+	// parser are invalidated by the next parse
+	var val *fastjson.Value
+	if val, err = fastjson.ParseBytes(bytes); err != nil {
+		if c.malformed != nil {
+			c.malformed.Set(url, nil)
+		}
+		return nil, &ParseError{Url: url, Err: err}
+	}
+
+	if c.cache != nil && !cached {
+		c.cache.Set(url, bytes)
+	}
+
+	return val, nil
+}
+
+// sharedFetch is the context of a fetch of an url and the number of callers
+// waiting for it.
+type sharedFetch struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiting int
+}
+
+// joinFetch returns the context of the fetch of url, counting the caller as
+// waiting for it. The fetch does not depend on the context of any caller,
+// it stops at RequestTimeout or once all of them left with leaveFetch.
+func (c *RateLimitedClient) joinFetch(url string) *sharedFetch {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	f, ok := c.fetches[url]
+	if !ok {
+		ctx, cancel := context.WithCancel(context.Background())
+		if config.RequestTimeout > 0 {
+			ctx, cancel = context.WithTimeout(context.Background(), config.RequestTimeout)
+		}
+		f = &sharedFetch{ctx: ctx, cancel: cancel}
+		c.fetches[url] = f
+	}
+	f.waiting++
+	return f
+}
+
+// leaveFetch stops waiting for the fetch of url, cancelling it if no other
+// caller waits for it. A cancelled fetch is forgotten, so later callers start
+// a new one instead of sharing its error.
+func (c *RateLimitedClient) leaveFetch(url string, f *sharedFetch) {
+	c.fetchMu.Lock()
+	defer c.fetchMu.Unlock()
+
+	f.waiting--
+	if f.waiting > 0 {
+		return
+	}
+	f.cancel()
+	if c.fetches[url] == f {
+		delete(c.fetches, url)
+		c.flight.Forget(url)
+	}
+}
+
+// bases returns the base url of the API followed by those of the mirrors.
+func (c *RateLimitedClient) bases() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]string{c.apiUrl}, c.mirrors...)
+}
+
+// fetchResult is the body of a fetch and the number of retries it took.
+type fetchResult struct {
+	body    []byte
+	retries int
+}
+
+// fetchFailover requests path from the bases in order, moving on to the next
+// one if the circuit breaker of a base is open or MangaDex is unavailable
+// there. Other errors, like a missing manga, are returned right away. Every
+// request after a failed one is a retry, at most retries of them are made
+// unless retries is negative.
+func (c *RateLimitedClient) fetchFailover(ctx context.Context, endpoint string, path string, bases []string, retries int) (fetchResult, error) {
+	c.mu.RLock()
+	timeout := c.mirrorTimeout
+	c.mu.RUnlock()
+
+	var err error
+	var result fetchResult
+	failed := false
+	for i, base := range bases {
+		b := c.breakers.get(base, endpoint)
+		if b.open() {
+			breakerRejections.Add(1)
+			err = &LimiterError{Err: ErrBreakerOpen}
+			continue
+		}
+
+		if failed {
+			if retries >= 0 && result.retries >= retries {
+				retryBudgetExceeded.Add(1)
+				fmt.Fprintf(gin.DefaultWriter, "[WARNING]: not trying %s, the request used up RETRY_BUDGET\n", base)
+				return result, err
+			}
+			result.retries++
+		}
+
+		// The last base gets whatever is left of the deadline
+		attemptCtx, cancel := ctx, context.CancelFunc(func() {})
+		if i < len(bases)-1 && timeout > 0 {
+			attemptCtx, cancel = context.WithTimeout(ctx, timeout)
+		}
+
+		result.body, err = c.fetch(attemptCtx, base+path)
+		cancel()
+		b.record(err)
+		if err == nil || !isFailure(err) || ctx.Err() != nil {
+			return result, err
+		}
+		failed = true
+
+		if i < len(bases)-1 {
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: %v, trying %s\n", err, bases[i+1])
+		}
+	}
+	return result, err
+}
+
+// fetch returns the body of a successful GET request to url.
+func (c *RateLimitedClient) fetch(ctx context.Context, url string) ([]byte, error) {
+	request, _ := http.NewRequestWithContext(ctx, "GET", url, nil)
 
 	var err error
 	var resp *http.Response
 	if resp, err = c.Do(request); err != nil {
-		return nil, fmt.Errorf("could not complete manga request: %w", err)
+		var limiterErr *LimiterError
+		if errors.As(err, &limiterErr) {
+			return nil, err
+		}
+		return nil, &UpstreamError{Url: url, Err: err}
 	}
 	defer resp.Body.Close()
 
+	c.adjustRate(resp.Header)
+
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("status not ok: %w", err)
+		if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+			return nil, &NotFoundError{Url: url, Code: resp.StatusCode}
+		}
+		return nil, &UpstreamError{Url: url, Code: resp.StatusCode}
 	}
 
 	var bytes []byte
 	if bytes, err = io.ReadAll(resp.Body); err != nil {
-		return nil, fmt.Errorf("could not unmarshal response: %w", err)
+		return nil, &UpstreamError{Url: url, Code: resp.StatusCode, Err: err}
 	}
 
-	var val *fastjson.Value
-	if val, err = parser.ParseBytes(bytes); err != nil {
-		return nil, fmt.Errorf("could not unmarshal response: %w", err)
-	}
-
-	return val, nil
+	return bytes, nil
 }
 
-func newRLClient(rl *rate.Limiter) *RateLimitedClient {
+func newRLClient(rl *rate.Limiter, clock Clock) *RateLimitedClient {
 	c := &RateLimitedClient{
 		client:      http.DefaultClient,
 		Ratelimiter: rl,
+		clock:       clock,
+		apiUrl:      "https://api.mangadex.org",
+		BaseRate:    rl.Limit(),
+		FloorRate:   rl.Limit(),
+		fetches:     make(map[string]*sharedFetch),
 	}
 	return c
 }
 
 func main() {
+	selfCheckFlag := flag.Bool("selfcheck", false, "render one embed, print the outcome and exit")
+	selfCheckManga := flag.String("selfcheck-id", selfCheckId, "manga rendered by -selfcheck")
+	flag.Parse()
+
+	// Variables of the config file are set before the config is read,
+	// the logger is not set up yet
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadEnvFile(path); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR]: %v\n", err)
+			os.Exit(1)
+		}
+	}
+	config = loadConfig()
+	setMaintenance(config.Maintenance)
+
 	// Setup logging
 	gin.DisableConsoleColor()
-	f, _ := os.OpenFile("gin.log", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	logOut := io.MultiWriter(f, os.Stdout)
+	logOut, logFile := logWriter(&config)
+	defer logFile.Close()
 	gin.DefaultWriter = logOut
 
 	// Creat mangadex API client
+	transport, err := newTransport(config.OutboundProxy)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		os.Exit(1)
+	}
 	createDexClient(logOut)
+	dexClient.client = &http.Client{Transport: transport}
+	coverClient = &http.Client{Transport: transport}
+	if config.WebhookUrl != "" {
+		webhook = newEventDispatcher(config.WebhookUrl, config.WebhookQueue, &http.Client{Timeout: config.WebhookTimeout})
+	}
+
+	// Setup templates
+	tmpl, err := loadTemplates("templates")
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		os.Exit(1)
+	}
+	templates = tmpl
+
+	if *selfCheckFlag {
+		if err := selfCheck(tmpl, *selfCheckManga); err != nil {
+			fmt.Fprintf(os.Stderr, "[ERROR]: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Printf("selfcheck: embed of %s rendered\n", *selfCheckManga)
+		os.Exit(0)
+	}
+
+	r := newRouter(tmpl)
+
+	addr, err := resolveAddress(config.ListenAddr, os.Getenv("PORT"))
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		os.Exit(1)
+	}
+
+	srv := newServer(addr, r)
+
+	if dexClient.cache != nil && config.CacheFile != "" {
+		if err := dexClient.cache.Load(config.CacheFile); err != nil && !errors.Is(err, os.ErrNotExist) {
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: could not load cache: %v\n", err)
+		}
+	}
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Reload on SIGHUP, wait for another signal to shut down gracefully
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for s := <-sig; s == syscall.SIGHUP; s = <-sig {
+		reloadConfig()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: could not shut down: %v\n", err)
+	}
 
-	// Init GIN router
+	if dexClient.cache != nil && config.CacheFile != "" {
+		if err := dexClient.cache.Save(config.CacheFile); err != nil {
+			fmt.Fprintf(gin.DefaultWriter, "[ERROR]: could not save cache: %v\n", err)
+		}
+	}
+}
+
+// newRouter sets up the middleware and routes of the service with the
+// templates, using the dexClient and config set up before.
+func newRouter(tmpl *template.Template) *gin.Engine {
 	r := gin.New()
+	r.RedirectTrailingSlash = config.RedirectTrailingSlash
+	r.RedirectFixedPath = config.RedirectFixedPath
 
 	// Setup middleware
+	r.Use(requestId)
 	r.Use(gin.Logger())
-	r.Use(gin.Recovery())
+	r.Use(recovery)
+	r.Use(maintenanceMode)
+	r.Use(lowerIds)
 
-	// Setup templates
-	r.LoadHTMLGlob("templates/*")
+	r.SetHTMLTemplate(tmpl)
+	useMinifiedHTML(r)
 
-	// Setup routes
-	r.GET("/", func(c *gin.Context) {
-		c.HTML(http.StatusOK, "index.html", gin.H{})
+	// Setup routes, all are served under the configured base path
+	base := r.Group(config.BasePath + "/")
+
+	base.GET("/", func(c *gin.Context) {
+		c.HTML(http.StatusOK, "index.html", gin.H{
+			"og_title":   config.ServiceName,
+			"og_content": config.ServiceTagline,
+			"og_name":    config.ServiceName,
+			"og_image":   config.ServiceImage,
+		})
+	})
+
+	r.NoRoute(func(c *gin.Context) {
+		renderError(c, http.StatusNotFound, "")
 	})
 
-	r.GET("/title/:md-id", createEmbed)
-	r.GET("/title/:md-id/:manga-name", createEmbed)
+	// Embeds and covers share the request queue, the other routes are
+	// always handled
+	requestQueue = newFairQueue(config.MaxConcurrency, config.MaxQueued, config.QueueWeights)
+	metadata, proxy := admit("metadata"), admit("proxy")
+
+	base.GET("/title/:md-id", metadata, createEmbed)
+	base.GET("/title/:md-id/:manga-name", metadata, createEmbed)
+
+	base.GET("/titles", metadata, createGroupEmbed)
+	base.GET("/user/:user-id", metadata, createUserEmbed)
+
+	if info, err := os.Stat(config.StaticDir); err == nil && info.IsDir() {
+		base.GET("/static/*filepath", serveStatic)
+	}
+
+	// Legacy paths of shared links, the embed still redirects to /title
+	for _, alias := range []string{"/manga", "/titles"} {
+		base.GET(alias+"/:md-id", metadata, createEmbed)
+		base.GET(alias+"/:md-id/:manga-name", metadata, createEmbed)
+	}
+
+	base.GET("/embed", metadata, embedFromUrl)
+	base.GET("/api/title/:md-id", metadata, apiEmbed)
+	base.GET("/discord/:md-id", metadata, discordEmbedJSON)
+	base.GET("/feed/:feed", metadata, createFeed)
+	base.GET("/cover/:md-id", proxy, coverImage)
+	base.GET("/cover/:md-id/:filename", proxy, proxyCover)
+	base.GET("/stats", gin.WrapH(expvar.Handler()))
+	base.GET("/ready", ready)
+
+	if config.AdminToken != "" && dexClient.cache != nil {
+		base.POST("/warm", limitBody(config.MaxBodyBytes), requireAdmin, warm)
+	}
+	if config.AdminToken != "" {
+		base.POST("/maintenance", limitBody(config.MaxBodyBytes), requireAdmin, toggleMaintenance)
+		base.GET("/ratelimit", requireAdmin, showRateLimit)
+		base.POST("/ratelimit", limitBody(config.MaxBodyBytes), requireAdmin, tuneRateLimit)
+	}
+
+	return r
+}
+
+// newServer returns the server of the handler listening on addr.
+// Requests with larger headers are rejected by net/http with a 431,
+// the timeouts keep slow clients from holding connections open.
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+		ReadHeaderTimeout: config.ReadHeaderTimeout,
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+	}
+}
+
+// resolveAddress returns the address to listen on from a host, host:port or
+// [ipv6]:port address and the default port. Bare IPv6 literals such as `::`
+// are accepted as well, an empty host listens on all interfaces.
+func resolveAddress(addr string, port string) (string, error) {
+	if port == "" {
+		port = "8080"
+	}
+
+	host := addr
+	if ip := net.ParseIP(strings.Trim(addr, "[]")); ip != nil {
+		host = ip.String()
+	} else if strings.Contains(addr, ":") {
+		var err error
+		if host, port, err = net.SplitHostPort(addr); err != nil {
+			return "", fmt.Errorf("invalid listen address %q: %w", addr, err)
+		}
+	}
+
+	if p, err := strconv.Atoi(port); err != nil || p < 0 || p > 65535 {
+		return "", fmt.Errorf("invalid port %q", port)
+	}
 
-	r.Run()
+	return net.JoinHostPort(host, port), nil
 }
 
 func createDexClient(logOut io.Writer) {
-	rl := rate.NewLimiter(rate.Every(2*time.Second), 5)
-	dexClient = newRLClient(rl)
+	rl := rate.NewLimiter(rate.Limit(config.RateLimit), config.RateBurst)
+	dexClient = newRLClient(rl, realClock{})
+	dexClient.MaxWaiting = int64(config.MaxWaiting)
+	dexClient.apiUrl = config.ApiUrl
+	dexClient.mirrors = config.ApiMirrors
+	dexClient.mirrorTimeout = config.MirrorTimeout
+	dexClient.Adaptive = config.AdaptiveRateLimit
+	dexClient.FloorRate = rate.Limit(config.RateFloor)
+	dexClient.LowQuota = config.LowQuota
+	dexClient.budgets = map[string]semaphore{
+		mangaEndpoint:  newSemaphore(config.MangaConcurrency),
+		authorEndpoint: newSemaphore(config.AuthorConcurrency),
+		coverEndpoint:  newSemaphore(config.CoverConcurrency),
+	}
+	var endpoints []string
+	for endpoint := range dexClient.budgets {
+		endpoints = append(endpoints, endpoint)
+	}
+	dexClient.breakers = newBreakerSet(endpoints, config.BreakerThreshold, config.BreakerCooldown, realClock{})
+	coverProxySem = newSemaphore(config.CoverProxyConcurrency)
+	renderSem = newSemaphore(config.RenderConcurrency)
+
+	if config.CacheTTL > 0 {
+		dexClient.cache = newCache(config.CacheTTL, config.CacheMaxEntries, realClock{})
+		dexClient.cache.SetJitter(config.CacheJitter)
+	}
+	if config.MalformedCacheTTL > 0 {
+		dexClient.malformed = newCache(config.MalformedCacheTTL, config.CacheMaxEntries, realClock{})
+	}
 }
 
-func parseMangaResponse(val *fastjson.Value, mangaId string) gin.H {
-	attr := val.Get("data").Get("attributes")
+// fatalError returns err if the relationship fetches should stop,
+// either because the request was cancelled or its deadline passed.
+// Other errors only leave the relationship out of the embed.
+func fatalError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
 
-	titleObj := attr.GetObject("title")
-	var title string
-	var language string
-	titleObj.Visit(func(key []byte, v *fastjson.Value) {
-		language = string(key)
-		t, _ := v.StringBytes()
-		title = string(t)
-	})
+// followedRelationships picks the author, artist and cover relationships
+// that are looked up, at most max of them unless max is 0. The first cover
+// goes first, then the authors in order, then the artists.
+// Covers included in the response and relationships without an id do not
+// count towards max.
+func followedRelationships(rel []*fastjson.Value, max int) []bool {
+	followed := make([]bool, len(rel))
+	remaining := max
 
-	var desc string
-	found := false
-	attr.GetObject("description").Visit(func(key []byte, v *fastjson.Value) {
-		if found {
+	follow := func(i int) {
+		if followed[i] || (max > 0 && remaining == 0) {
 			return
 		}
 
-		lang := string(key)
+		followed[i] = true
+		if len(rel[i].GetStringBytes("id")) > 0 && len(rel[i].Get("attributes").GetStringBytes("fileName")) == 0 {
+			remaining--
+		}
+	}
 
-		d, _ := v.StringBytes()
-		desc = string(d)
+	// Of several covers only the first that can be looked up is followed,
+	// or the first one so a missing id is still reported
+	if i := firstCover(rel); i >= 0 {
+		follow(i)
+	}
 
-		if lang == language {
-			found = true
+	authorIds := map[string]bool{}
+	for i, v := range rel {
+		if string(v.GetStringBytes("type")) == "author" && len(v.GetStringBytes("id")) > 0 {
+			follow(i)
+			authorIds[string(v.GetStringBytes("id"))] = true
 		}
-	})
+	}
 
-	cover := ""
-	rel := val.Get("data").GetArray("relationships")
-	for _, v := range rel {
+	// Artists who are also an author are not looked up twice
+	for i, v := range rel {
+		id := string(v.GetStringBytes("id"))
+		if string(v.GetStringBytes("type")) == "artist" && id != "" && !authorIds[id] {
+			follow(i)
+		}
+	}
+
+	return followed
+}
+
+// firstCover returns the index of the first cover_art relationship with an
+// id or file name, of the first cover_art relationship if none has one,
+// or -1 if there is no cover_art relationship at all.
+func firstCover(rel []*fastjson.Value) int {
+	first := -1
+	for i, v := range rel {
+		if string(v.GetStringBytes("type")) != "cover_art" {
+			continue
+		}
+
+		if len(v.GetStringBytes("id")) > 0 || len(v.Get("attributes").GetStringBytes("fileName")) > 0 {
+			return i
+		}
+		if first < 0 {
+			first = i
+		}
+	}
+	return first
+}
+
+// parseMangaResponse builds the embed data, fetching the authors and cover
+// of the manga. The title and description are picked in the order of langs, if present.
+// An error is only returned when ctx is done.
+func parseMangaResponse(ctx context.Context, val *fastjson.Value, mangaId string, langs []string) (gin.H, error) {
+	// Nothing else is looked up without the manga, MangaDex is likely
+	// failing already
+	if val.Get("data") == nil {
+		return nil, &ParseError{Url: fmt.Sprintf(mangaEndpoint, mangaId), Err: errors.New("response has no data")}
+	}
+	attr := val.Get("data").Get("attributes")
+	site := fmt.Sprintf("%s/title/%s", config.SiteUrl, mangaId)
+
+	// Drafts and manga awaiting review are not embedded as normal, the state
+	// is missing from older responses
+	state := string(attr.GetStringBytes("state"))
+	unpublished := state != "" && state != "published" && !config.EmbedUnpublished
+	if unpublished || attr.GetBool("isLocked") && !config.EmbedLocked {
+		return gin.H{
+			"og_title": config.UnpublishedMessage,
+			"og_name":  site,
+			"redirect": site,
+		}, nil
+	}
+
+	// The title is in a requested language if there is one, otherwise the
+	// main title is localized like the description
+	title, language := localize(attr.GetObject("title"), nil)
+	titleLanguage := language
+	if localized, lang := localizedTitle(attr, langs); lang != "" {
+		title, titleLanguage = localized, lang
+	}
+
+	// Without a usable main title the first alt title stands in for it
+	for _, alt := range attr.GetArray("altTitles") {
+		if title != "" {
+			break
+		}
+		if t, l := localize(alt.GetObject(), nil); t != "" && !config.hidesAltTitle(l) {
+			title, language, titleLanguage = t, l, l
+		}
+	}
+	if title == "" {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: manga %s has no title\n", mangaId)
+	}
+
+	if config.PreferRomaji && !isLatin(title) {
+		if romaji := romajiTitle(attr); romaji != "" {
+			title, titleLanguage = romaji, string(attr.GetStringBytes("originalLanguage"))+"-ro"
+		}
+	}
+
+	// The other of the original and localized title is shown as subtitle
+	var subtitle string
+	if original := originalTitle(attr); original != "" && original != title {
+		if config.TitlePrimary == "original" {
+			title, subtitle = original, title
+			titleLanguage = string(attr.GetStringBytes("originalLanguage"))
+		} else {
+			subtitle = original
+		}
+	}
+
+	if config.SanitizeTitles {
+		title, subtitle = sanitizeTitle(title), sanitizeTitle(subtitle)
+	}
+	if config.TitleCase {
+		title = normalizeCase(title, titleLanguage)
+	}
+
+	// The requested languages take precedence over the title language,
+	// the configured fallback is used if there is no description at all
+	desc, descLanguage := localizeDescription(attr.GetObject("description"), append(append([]string{}, langs...), language))
+	if desc == "" {
+		desc = fallbackDescription(attr)
+	}
+	if descLanguage == "" {
+		descLanguage = language
+	}
+	locale, alternates := embedLocales(descLanguage, attr.GetObject("title"), attr.GetObject("description"))
+
+	// Relationships are fetched concurrently, results are stored by index
+	// so the authors keep the order of the relationships
+	// Without relationships the embed is still rendered, without authors
+	// and with the fallback cover
+	relValue := val.Get("data", "relationships")
+	if relValue == nil || relValue.Type() != fastjson.TypeArray {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: manga %s has no relationships\n", mangaId)
+	}
+	rel := relValue.GetArray()
+	authors := make([]string, len(rel))
+	artists := make([]string, len(rel))
+	covers := make([]coverArt, len(rel))
+	followed := followedRelationships(rel, config.MaxRelationships)
+
+	g, gctx := errgroup.WithContext(ctx)
+	for i, v := range rel {
+		i := i
+		relId := string(v.GetStringBytes("id"))
 		relType := string(v.GetStringBytes("type"))
-		if relType == "author" {
-			authorId := string(v.GetStringBytes("id"))
-			authorJSON, err := dexClient.RequestJSON(authorEndpoint, string(authorId))
 
-			if err != nil {
-				continue
-			}
+		// Without an id there is nothing to look up
+		if relId == "" && (relType == "author" || relType == "artist") {
+			emptyRelationshipIds.Add(1)
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: %s relationship of manga %s has no id\n", relType, mangaId)
+			continue
+		}
+
+		if !followed[i] {
+			continue
+		}
+
+		switch relType {
+		case "author":
+			g.Go(func() error {
+				authorJSON, err := dexClient.RequestJSON(gctx, authorEndpoint, relId)
+				if err != nil {
+					return fatalError(gctx, err)
+				}
+
+				authors[i] = string(authorJSON.Get("data").Get("attributes").GetStringBytes("name"))
+				return nil
+			})
+		case "artist":
+			g.Go(func() error {
+				artistJSON, err := dexClient.RequestJSON(gctx, authorEndpoint, relId)
+				if err != nil {
+					return fatalError(gctx, err)
+				}
+
+				artists[i] = string(artistJSON.Get("data").Get("attributes").GetStringBytes("name"))
+				return nil
+			})
+		case "cover_art":
+			v := v
+			g.Go(func() error {
+				cover, err := relationshipCover(gctx, mangaId, v)
+				if err != nil {
+					return fatalError(gctx, err)
+				}
 
-			author := string(authorJSON.Get("data").Get("attributes").GetStringBytes("name"))
-			title = strings.Join([]string{title, " - ", author}, " ")
+				covers[i] = cover
+				return nil
+			})
 		}
+	}
 
-		if relType == "cover_art" {
-			coverId := string(v.GetStringBytes("id"))
-			coverJSON, err := dexClient.RequestJSON(coverEndpoint, string(coverId))
+	// Related manga are looked up together in one request
+	var related []relatedManga
+	if config.MaxRelated > 0 {
+		ids, relations := relatedIds(rel, config.MaxRelated)
+		g.Go(func() error {
+			r, err := fetchRelated(gctx, ids, relations, langs)
+			if err != nil {
+				return fatalError(gctx, err)
+			}
+
+			related = r
+			return nil
+		})
+	}
 
+	// The cover in a requested language replaces the main cover
+	var localized coverArt
+	if config.LocalizedCovers && len(langs) > 0 {
+		g.Go(func() error {
+			cover, err := localeCover(gctx, mangaId, langs)
 			if err != nil {
-				continue
+				return fatalError(gctx, err)
 			}
 
-			filename := string(coverJSON.Get("data").Get("attributes").GetStringBytes("fileName"))
-			cover = fmt.Sprintf(CoverUri, mangaId, filename)
+			localized = cover
+			return nil
+		})
+	}
+
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+
+	// Artists who are also an author were not looked up
+	authorsById := map[string]string{}
+	for i, v := range rel {
+		if string(v.GetStringBytes("type")) == "author" && authors[i] != "" {
+			authorsById[string(v.GetStringBytes("id"))] = authors[i]
+		}
+	}
+	for i, v := range rel {
+		if string(v.GetStringBytes("type")) == "artist" && artists[i] == "" {
+			artists[i] = authorsById[string(v.GetStringBytes("id"))]
 		}
+	}
 
+	if config.SanitizeTitles {
+		for i := range authors {
+			authors[i], artists[i] = sanitizeTitle(authors[i]), sanitizeTitle(artists[i])
+		}
 	}
 
-	site := fmt.Sprintf("https://mangadex.org/title/%s", mangaId)
+	plainTitle := title
+	title = formatAuthors(title, authors, config.AuthorFormat)
+	if n := config.TitleIdLength; n > 0 {
+		title = fmt.Sprintf("%s [%s]", title, shortId(mangaId, n))
+	}
+	if flag := languageFlag(string(attr.GetStringBytes("originalLanguage"))); config.TitleFlag && flag != "" {
+		title = flag + " " + title
+	}
+
+	var authorNames, artistNames []string
+	for i := range rel {
+		if authors[i] != "" {
+			authorNames = append(authorNames, authors[i])
+		}
+		if artists[i] != "" {
+			artistNames = append(artistNames, artists[i])
+		}
+	}
+
+	// Of several covers the first one is used, like on MangaDex
+	var cover coverArt
+	for _, c := range covers {
+		if c.url != "" {
+			cover = c
+			break
+		}
+	}
+	if localized.url != "" {
+		cover = localized
+	}
+	if cover.url == "" {
+		cover.url = config.FallbackCover
+	}
+
+	allTags := parseTags(attr)
+	tags := selectTags(allTags, config.TagGenresFirst, config.MaxTags)
+	tagGroups := groupTags(allTags)
+
 	return gin.H{
-		"og_title":   title,
-		"og_content": desc,
-		"og_name":    site,
-		"og_image":   cover,
-		"redirect":   site,
+		"og_title":          title,
+		"title":             plainTitle,
+		"subtitle":          subtitle,
+		"authors":           authorNames,
+		"artists":           artistNames,
+		"credit":            creditLine(authorNames, artistNames),
+		"og_content":        desc,
+		"og_name":           site,
+		"og_image":          cover.url,
+		"og_image_alt":      coverAlt(cover.volume, cover.description),
+		"cover_volume":      cover.volume,
+		"cover_description": cover.description,
+		"og_locale":         locale,
+		"og_locales":        alternates,
+		"og_links":          parseLinks(attr.GetObject("links"), config.LinkKeys, config.MaxLinks),
+		"tags":              strings.Join(tags, ", "),
+		"tag_list":          tags,
+		"genres":            strings.Join(tagGroups["genre"], ", "),
+		"themes":            strings.Join(tagGroups["theme"], ", "),
+		"formats":           strings.Join(tagGroups["format"], ", "),
+		"content_tags":      strings.Join(tagGroups["content"], ", "),
+		"other_tags":        strings.Join(tagGroups["other"], ", "),
+		"tag_groups":        tagGroups,
+		"related":           related,
+		"year":              releaseYear(attr),
+		"updated_at":        updatedAt(attr),
+		"kind":              contentKind(allTags, config.KindTags),
+		"status":            strings.Title(string(attr.GetStringBytes("status"))),
+		"content_rating":    string(attr.GetStringBytes("contentRating")),
+		"original_language": string(attr.GetStringBytes("originalLanguage")),
+		"noembed":           config.isNoEmbed(mangaId, allTags),
+		"redirect":          site,
+	}, nil
+}
+
+// fallbackDescription returns the text shown when the manga has no description,
+// depending on the configured fallback.
+func fallbackDescription(attr *fastjson.Value) string {
+	switch config.DescriptionFallback {
+	case "alt_title":
+		for _, alt := range attr.GetArray("altTitles") {
+			var title string
+			alt.GetObject().Visit(func(key []byte, v *fastjson.Value) {
+				if title == "" && !config.hidesAltTitle(string(key)) {
+					title = localizedString(key, v)
+				}
+			})
+
+			if title != "" {
+				return title
+			}
+		}
+		return config.DescriptionPlaceholder
+	case "tags":
+		if desc := tagDescription(attr); desc != "" {
+			return desc
+		}
+		return config.DescriptionPlaceholder
+	case "placeholder":
+		return config.DescriptionPlaceholder
+	default:
+		return ""
+	}
+}
+
+// tagDescription describes the manga by its first genres, status and
+// demographic, e.g. "Action, Fantasy — Ongoing seinen manga".
+func tagDescription(attr *fastjson.Value) string {
+	var genres []string
+	for _, t := range parseTags(attr) {
+		if t.Group == "genre" && len(genres) < 3 {
+			genres = append(genres, t.Name)
+		}
+	}
+
+	// Korean and chinese comics are called manhwa and manhua
+	kind := "manga"
+	switch strings.SplitN(string(attr.GetStringBytes("originalLanguage")), "-", 2)[0] {
+	case "ko":
+		kind = "manhwa"
+	case "zh":
+		kind = "manhua"
+	}
+
+	var words []string
+	if status := string(attr.GetStringBytes("status")); status != "" {
+		words = append(words, status)
+	}
+	if demographic := string(attr.GetStringBytes("publicationDemographic")); demographic != "" {
+		words = append(words, demographic)
+	}
+	if len(words) == 0 && len(genres) == 0 {
+		return ""
+	}
+
+	words = append(words, kind)
+	summary := strings.Join(words, " ")
+	summary = strings.ToUpper(summary[:1]) + summary[1:]
+	if len(genres) == 0 {
+		return summary
 	}
+	return strings.Join(genres, ", ") + " — " + summary
+}
+
+// coverAlt describes the cover using its volume and description, if any.
+func coverAlt(volume string, desc string) string {
+	if volume == "" {
+		return desc
+	}
+
+	alt := fmt.Sprintf("Volume %s cover", volume)
+	if desc != "" {
+		alt = fmt.Sprintf("%s: %s", alt, desc)
+	}
+	return alt
 }
 
 func createEmbed(c *gin.Context) {
-	mangaId := c.Param("md-id")
+	if c.Param("manga-name") == "name.txt" || c.Query("format") == "txt" {
+		titleText(c)
+		return
+	}
+
+	if c.Param("manga-name") == "cover.jpg" {
+		coverRedirect(c)
+		return
+	}
+
+	if !config.validSlug(c.Param("manga-name")) {
+		renderError(c, http.StatusBadRequest, "Invalid manga name.")
+		return
+	}
+
+	// The same data is sent as JSON or the plain title if asked for,
+	// crawlers and browsers get the embed page
+	c.Header("Vary", "Accept")
+	switch c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON, gin.MIMEPlain) {
+	case gin.MIMEJSON:
+		apiEmbed(c)
+	case gin.MIMEPlain:
+		titleText(c)
+	default:
+		renderEmbed(c, c.Param("md-id"))
+	}
+}
+
+// setCanonical points crawlers reading the Link header to the MangaDex page
+// of the manga and returns its url for the <link rel=canonical> of the template.
+func setCanonical(c *gin.Context, mangaId string) string {
+	canonical := fmt.Sprintf("%s/title/%s", config.SiteUrl, url.PathEscape(mangaId))
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="canonical"`, canonical))
+	return canonical
+}
+
+// renderSem limits the number of embeds rendered at the same time.
+var renderSem semaphore
+
+func renderEmbed(c *gin.Context, mangaId string) {
+	start := time.Now()
+	defer func() {
+		webhook.emit(embedEvent{
+			Id:      mangaId,
+			Status:  c.Writer.Status(),
+			Latency: float64(time.Since(start).Microseconds()) / 1000,
+			Time:    start,
+		})
+	}()
+
+	// Flagged ids do not need anything from MangaDex
+	if config.NoEmbedIds[strings.ToLower(mangaId)] && !config.isBlocked(mangaId) {
+		c.HTML(http.StatusOK, "noembed.html", gin.H{
+			"redirect":  fmt.Sprintf("%s/title/%s", config.SiteUrl, mangaId),
+			"canonical": setCanonical(c, mangaId),
+		})
+		return
+	}
+
+	comicMeta, status := resolveEmbed(c, mangaId)
+	if comicMeta == nil {
+		renderError(c, status, "")
+		return
+	}
+
+	// The hash is taken before the keys only the page has are changed, so
+	// the ETag of the page and the JSON differs only by format
+	etag := setETag(c, comicMeta, "html")
+	modified := setLastModified(c, comicMeta)
+	if status == http.StatusOK && notModified(c, etag, modified) {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+
+	// Only the rendering takes a slot, waiting on MangaDex is not CPU bound
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.RenderQueueTimeout)
+	err := renderSem.acquire(ctx)
+	cancel()
+	if err != nil {
+		renderRejections.Add(1)
+		c.Header("Retry-After", "1")
+		renderError(c, http.StatusServiceUnavailable, "")
+		return
+	}
+	defer renderSem.release()
+
+	comicMeta["canonical"] = setCanonical(c, mangaId)
 
-	comicJSON, err := dexClient.RequestJSON(mangaEndpoint, mangaId)
-	comicMeta := parseMangaResponse(comicJSON, mangaId)
+	template := "embed.html"
+	if noEmbed, _ := comicMeta["noembed"].(bool); noEmbed {
+		template = "noembed.html"
+	}
+
+	// Redirecting to ourselves would make browsers loop forever
+	if redirect, _ := comicMeta["redirect"].(string); isSelfRedirect(redirect, c.Request.Host) {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: redirect %s points to this service, check SITE_URL\n", redirect)
+		delete(comicMeta, "redirect")
+	}
+
+	// Removed manga may come back, so crawlers should check again soon
+	if unavailable, _ := comicMeta["unavailable"].(bool); unavailable {
+		c.Header("Cache-Control", fmt.Sprintf("public, max-age=%d", int(config.UnavailableCacheTTL.Seconds())))
+	}
+
+	if template == "embed.html" && comicMeta["title"] != nil {
+		comicMeta["json_ld"] = embedJSONLD(comicMeta)
+	}
+	c.HTML(status, template, comicMeta)
+}
+
+// resolveEmbed returns the embed data of the manga and the status to respond with,
+// the data is nil if there is nothing to render.
+func resolveEmbed(c *gin.Context, mangaId string) (gin.H, int) {
+	if config.isBlocked(mangaId) {
+		return gin.H{
+			"og_title": config.BlockedMessage,
+		}, config.BlockedStatus
+	}
+
+	// Once the budget is used up the embed is rendered with what was fetched
+	budgets := withRetryBudget(withCallBudget(c.Request.Context(), config.MaxUpstreamCalls), config.RetryBudget)
+	ctx, cancel := context.WithTimeout(budgets, config.RequestTimeout)
+	defer cancel()
+
+	comicJSON, err := dexClient.RequestJSON(ctx, mangaEndpoint, mangaId)
 
-	var status int
+	// Removed manga get their own embed instead of an error
+	var notFoundErr *NotFoundError
+	if errors.As(err, &notFoundErr) {
+		site := fmt.Sprintf("%s/title/%s", config.SiteUrl, mangaId)
+		return gin.H{
+			"og_title":    config.UnavailableMessage,
+			"og_name":     site,
+			"redirect":    site,
+			"unavailable": true,
+		}, http.StatusNotFound
+	}
 	if err != nil {
-		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v", err)
-		status = http.StatusBadRequest
-	} else {
-		status = http.StatusOK
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		return nil, errorStatus(err)
+	}
+
+	comicMeta, parseErr := parseMangaResponse(ctx, comicJSON, mangaId, requestLanguages(c))
+	if parseErr != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", parseErr)
+		return nil, errorStatus(parseErr)
+	}
+
+	// Hidden covers are only replaced by the cover proxy, the fallback
+	// cover is not from MangaDex and never proxied
+	rating, _ := comicMeta["content_rating"].(string)
+	cover, _ := comicMeta["og_image"].(string)
+	if (config.CoverProxy || config.hidesCover(rating)) && cover != "" && strings.HasPrefix(cover, config.UploadsUrl) {
+		comicMeta["og_image"] = proxiedCoverUrl(c, mangaId, cover)
+	}
+	if config.Attribution != "" {
+		comicMeta["attribution"] = config.Attribution
 	}
 
-	c.HTML(status, "embed.html", comicMeta)
+	return comicMeta, http.StatusOK
 }
@@ -0,0 +1,1105 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+	"github.com/valyala/fastjson"
+	"golang.org/x/time/rate"
+)
+
+func TestWaitUsesClock(t *testing.T) {
+	clock := newFakeClock()
+	client := newRLClient(rate.NewLimiter(1, 1), clock)
+
+	if err := client.wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- client.wait(context.Background()) }()
+
+	select {
+	case <-done:
+		t.Fatal("second request was let through before the clock moved")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	clock.Advance(time.Second)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("request still waiting after the clock moved")
+	}
+}
+
+func TestCoverAlt(t *testing.T) {
+	tests := []struct {
+		volume, desc, want string
+	}{
+		{"3", "Light and Ryuk", "Volume 3 cover: Light and Ryuk"},
+		{"3", "", "Volume 3 cover"},
+		{"", "Light and Ryuk", "Light and Ryuk"},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		if got := coverAlt(tt.volume, tt.desc); got != tt.want {
+			t.Errorf("coverAlt(%q, %q) = %q, want %q", tt.volume, tt.desc, got, tt.want)
+		}
+	}
+}
+
+func TestDoShedsLoad(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer srv.Close()
+
+	clock := newFakeClock()
+	client := newRLClient(rate.NewLimiter(1, 1), clock)
+	client.client = srv.Client()
+	client.MaxWaiting = 2
+
+	do := func() error {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		resp, err := client.Do(req)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+
+	// The burst lets the first request through, the next ones wait
+	if err := do(); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() { done <- do() }()
+	}
+	for atomic.LoadInt64(&client.waiting) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	shed := limiterErrors.Value()
+	var limiterErr *LimiterError
+	if err := do(); !errors.As(err, &limiterErr) || !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("request over MaxWaiting = %v, want ErrOverloaded", err)
+	}
+	if n := limiterErrors.Value() - shed; n != 1 {
+		t.Errorf("limiter_errors grew by %d, want 1", n)
+	}
+
+	// The waiting requests still go through once the limiter allows them
+	for finished := 0; finished < 2; {
+		clock.Advance(time.Second)
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("waiting request = %v, want no error", err)
+			}
+			finished++
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if n := atomic.LoadInt64(&client.waiting); n != 0 {
+		t.Errorf("%d requests still counted as waiting", n)
+	}
+}
+
+func TestFallbackDescription(t *testing.T) {
+	config = loadConfig()
+	attr := fastjson.MustParse(`{
+		"description": {},
+		"altTitles": [{"ko": "탑을 오르는 자"}, {"en": "Tower Climber"}],
+		"originalLanguage": "ko",
+		"status": "ongoing"
+	}`)
+
+	tests := []struct {
+		fallback string
+		hidden   []string
+		want     string
+	}{
+		{"alt_title", nil, "탑을 오르는 자"},
+		{"alt_title", []string{"ko"}, "Tower Climber"},
+		{"alt_title", []string{"ko", "en"}, config.DescriptionPlaceholder},
+		{"placeholder", nil, config.DescriptionPlaceholder},
+		{"none", nil, ""},
+	}
+
+	for _, tt := range tests {
+		config.DescriptionFallback = tt.fallback
+		config.HiddenTitleLanguages = tt.hidden
+		if got := fallbackDescription(attr); got != tt.want {
+			t.Errorf("fallbackDescription with %s hiding %v = %q, want %q", tt.fallback, tt.hidden, got, tt.want)
+		}
+	}
+}
+
+func TestParseMangaResponseCancelled(t *testing.T) {
+	_, fixtures := newTestService(t, nil)
+
+	// The authors respond only once the test is over
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	for _, id := range []string{"8b2c3d4e-5f6a-4b7c-9d8e-0f1a2b3c4d5e", "6c3d4e5f-6a7b-4c8d-ae9f-1a2b3c4d5e6f"} {
+		fixtures.handle("/author/"+id, func(w http.ResponseWriter, r *http.Request) {
+			started <- struct{}{}
+			select {
+			case <-release:
+			case <-r.Context().Done():
+			}
+		})
+	}
+
+	manga, err := dexClient.RequestJSON(context.Background(), mangaEndpoint, "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := parseMangaResponse(ctx, manga, "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f", nil)
+		done <- err
+	}()
+
+	<-started
+	<-started
+	cancel()
+
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("parseMangaResponse = %v, want context.Canceled", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("parseMangaResponse still waits on the authors after the cancel")
+	}
+}
+
+func TestSharedFetchCancelled(t *testing.T) {
+	_, fixtures := newTestService(t, nil)
+
+	// The author responds only once the test is over, and reports when its
+	// request was cancelled
+	started := make(chan struct{}, 1)
+	stopped := make(chan struct{}, 1)
+	release := make(chan struct{})
+	t.Cleanup(func() { close(release) })
+	fixtures.handle("/author/8b2c3d4e-5f6a-4b7c-9d8e-0f1a2b3c4d5e", func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			stopped <- struct{}{}
+		}
+	})
+
+	request := func(ctx context.Context) chan error {
+		done := make(chan error, 1)
+		go func() {
+			_, err := dexClient.RequestJSON(ctx, authorEndpoint, "8b2c3d4e-5f6a-4b7c-9d8e-0f1a2b3c4d5e")
+			done <- err
+		}()
+		return done
+	}
+
+	first, cancelFirst := context.WithCancel(context.Background())
+	second, cancelSecond := context.WithCancel(context.Background())
+	firstDone := request(first)
+	<-started
+	secondDone := request(second)
+	for waiting := 0; waiting < 2; time.Sleep(time.Millisecond) {
+		dexClient.fetchMu.Lock()
+		if f := dexClient.fetches[dexClient.bases()[0]+"/author/8b2c3d4e-5f6a-4b7c-9d8e-0f1a2b3c4d5e"]; f != nil {
+			waiting = f.waiting
+		}
+		dexClient.fetchMu.Unlock()
+	}
+
+	// The fetch keeps running while the second request waits for it
+	cancelFirst()
+	if err := <-firstDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("first RequestJSON = %v, want context.Canceled", err)
+	}
+	select {
+	case <-stopped:
+		t.Fatal("the author request was cancelled while a caller still waits for it")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// and stops as soon as no request waits anymore
+	cancelSecond()
+	if err := <-secondDone; !errors.Is(err, context.Canceled) {
+		t.Errorf("second RequestJSON = %v, want context.Canceled", err)
+	}
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("the author request still runs after every caller left")
+	}
+}
+
+func TestFatalError(t *testing.T) {
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		ctx  context.Context
+		err  error
+		want error
+	}{
+		{context.Background(), &NotFoundError{Url: "/author/x"}, nil},
+		{context.Background(), &UpstreamError{Url: "/author/x", Code: 500}, nil},
+		{context.Background(), &LimiterError{Err: context.DeadlineExceeded}, context.DeadlineExceeded},
+		{cancelled, &NotFoundError{Url: "/author/x"}, context.Canceled},
+	}
+
+	for _, tt := range tests {
+		if got := fatalError(tt.ctx, tt.err); !errors.Is(got, tt.want) || (tt.want == nil && got != nil) {
+			t.Errorf("fatalError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestServerRejectsLargeHeaders(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.MaxHeaderBytes = 4096
+	})
+
+	srv := httptest.NewUnstartedServer(r)
+	srv.Config = newServer("", r)
+	srv.Start()
+	defer srv.Close()
+
+	request := func(cookie string) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/ready", nil)
+		req.Header.Set("Cookie", cookie)
+		resp, err := srv.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	// net/http allows 4096 bytes more than the limit
+	if code := request(strings.Repeat("a", 16384)); code != http.StatusRequestHeaderFieldsTooLarge {
+		t.Errorf("status of oversized headers = %d, want 431", code)
+	}
+	if code := request("a=b"); code == http.StatusRequestHeaderFieldsTooLarge {
+		t.Error("small headers were rejected")
+	}
+}
+
+func TestResolveAddress(t *testing.T) {
+	tests := []struct {
+		addr, port, want string
+	}{
+		{"", "", ":8080"},
+		{"", "3000", ":3000"},
+		{"127.0.0.1", "", "127.0.0.1:8080"},
+		{"127.0.0.1:9000", "3000", "127.0.0.1:9000"},
+		{"::", "", "[::]:8080"},
+		{"[::]", "3000", "[::]:3000"},
+		{"[::1]:9000", "", "[::1]:9000"},
+		{"2001:db8::1", "", "[2001:db8::1]:8080"},
+		{"localhost", "", "localhost:8080"},
+		{"embed.example.com:443", "", "embed.example.com:443"},
+	}
+
+	for _, tt := range tests {
+		got, err := resolveAddress(tt.addr, tt.port)
+		if err != nil || got != tt.want {
+			t.Errorf("resolveAddress(%q, %q) = %q, %v, want %q", tt.addr, tt.port, got, err, tt.want)
+		}
+	}
+
+	for _, addr := range []string{"[::1]:http", "localhost:99999", "a:b:c"} {
+		if got, err := resolveAddress(addr, ""); err == nil {
+			t.Errorf("resolveAddress(%q) = %q, want an error", addr, got)
+		}
+	}
+	if got, err := resolveAddress("", "-1"); err == nil {
+		t.Errorf("resolveAddress with port -1 = %q, want an error", got)
+	}
+}
+
+func TestDoCancelledWait(t *testing.T) {
+	client := newRLClient(rate.NewLimiter(1, 1), newFakeClock())
+	client.wait(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://127.0.0.1:1", nil)
+	done := make(chan error, 1)
+	go func() {
+		_, err := client.Do(req)
+		done <- err
+	}()
+	cancel()
+
+	err := <-done
+	var limiterErr *LimiterError
+	if !errors.As(err, &limiterErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("Do with a cancelled wait = %v, want a LimiterError of context.Canceled", err)
+	}
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) {
+		t.Errorf("cancelled wait is also an UpstreamError: %v", err)
+	}
+}
+
+func TestEmbedUnpublished(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.EmbedLocked = false
+	})
+
+	tests := []struct {
+		id, title string
+	}{
+		{"3a5c7e9b-1d3f-4a5c-8e7b-9d1f3a5c7e9b", "Draft Series"},
+		{"6b8d0f2a-4c6e-4b8d-9f0a-2c4e6a8b0d2f", "Locked Series"},
+	}
+
+	for _, tt := range tests {
+		w := get(r, "/title/"+tt.id)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status of %s = %d, want 200", tt.title, w.Code)
+		}
+		assertContains(t, w.Body.String(), `<meta content="`+config.UnpublishedMessage+`" property="og:title">`)
+		if strings.Contains(w.Body.String(), tt.title) {
+			t.Errorf("embed of the unpublished %s shows its title", tt.title)
+		}
+	}
+
+	config.EmbedUnpublished = true
+	config.EmbedLocked = true
+	for _, tt := range tests {
+		assertContains(t, get(r, "/title/"+tt.id).Body.String(), `<meta content="`+tt.title+` - Azuma Kiyohiko" property="og:title">`)
+	}
+
+	// Published manga are embedded as normal either way
+	config.EmbedUnpublished = false
+	config.EmbedLocked = false
+	assertContains(t, get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d").Body.String(), `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`)
+}
+
+func TestEmbedAttribution(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	const path = "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	body := get(r, path).Body.String()
+	assertContains(t, body, `<meta content="https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d" property="og:site_name">`)
+	if strings.Contains(body, " · ") {
+		t.Errorf("embed without an attribution has one:\n%s", body)
+	}
+
+	config.Attribution = "Powered by md-rec"
+	body = get(r, path).Body.String()
+	assertContains(t, body, `<meta content="https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d · Powered by md-rec" property="og:site_name">`)
+	if strings.Count(body, "Powered by md-rec") != 1 {
+		t.Errorf("attribution is not only in og:site_name:\n%s", body)
+	}
+}
+
+func TestEmbedWithoutRelationships(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const mangaId = "8c0e2a4b-6d8f-4c0e-a2b4-6d8f0a2c4e6b"
+
+	check := func(name string) {
+		t.Helper()
+		w := get(r, "/title/"+mangaId)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status %s = %d, want 200", name, w.Code)
+		}
+		assertContains(t, w.Body.String(),
+			`<meta content="Orphaned Oneshot" property="og:title">`,
+			`<meta content="A oneshot whose relationships were not returned." property="og:description">`,
+			`<meta content="https://example.com/fallback.png" property='og:image'>`,
+		)
+	}
+
+	check("without relationships")
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes.Replace(fixture, []byte(`"attributes": {`), []byte(`"relationships": {"author": "9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"}, "attributes": {`), 1))
+	})
+	check("with relationships that are not an array")
+}
+
+func TestSharedAuthorFetchedOnce(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const authorPath = "/author/9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d"
+
+	author, err := os.ReadFile(filepath.Join("testdata", "mangadex", filepath.FromSlash(authorPath)+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	release := make(chan struct{})
+	fixtures.handle(authorPath, func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(author)
+	})
+
+	ids := []string{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a"}
+	for i := 0; i < 4; i++ {
+		id := fmt.Sprintf("00000000-0000-4000-8000-%012d", i)
+		ids = append(ids, id)
+		fixtures.handle("/manga/"+id, func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, `{"result": "ok", "data": {"id": %q, "type": "manga", "attributes": {"title": {"en": "Anthology %d"}}, "relationships": [{"id": "9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d", "type": "author"}]}}`, id, i)
+		})
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, len(ids))
+	for i, id := range ids {
+		wg.Add(1)
+		go func(i int, id string) {
+			defer wg.Done()
+			bodies[i] = get(r, "/title/"+id).Body.String()
+		}(i, id)
+	}
+
+	// All embeds wait for the author before it is returned
+	deadline := time.Now().Add(5 * time.Second)
+	for _, id := range ids {
+		for fixtures.count("/manga/"+id) == 0 && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := fixtures.count(authorPath); n != 1 {
+		t.Errorf("author requested %d times, want 1", n)
+	}
+	for i, body := range bodies {
+		if !strings.Contains(body, " - Azuma Kiyohiko\" property=\"og:title\"") {
+			t.Errorf("embed of %s has no author:\n%s", ids[i], body)
+		}
+	}
+}
+
+func TestEmbedBookMeta(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	body := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d").Body.String()
+	assertContains(t, body,
+		`<meta content="book" property="og:type">`,
+		`<meta content="Azuma Kiyohiko" property="book:author">`,
+		`<meta content="Comedy" property="book:tag">`,
+		`<meta content="Slice of Life" property="book:tag">`,
+		`<meta content="2003" property="book:release_date">`,
+	)
+	if n := strings.Count(body, `property="book:author"`); n != 1 {
+		t.Errorf("%d book:author tags for the same author and artist, want 1", n)
+	}
+
+	body = get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f").Body.String()
+	assertContains(t, body,
+		`<meta content="Ohba Tsugumi" property="book:author">`,
+		`<meta content="Obata Takeshi" property="book:author">`,
+	)
+
+	// Manga without a year have no release date
+	body = get(r, "/title/4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a").Body.String()
+	assertContains(t, body, `<meta content="book" property="og:type">`)
+	if strings.Contains(body, "book:release_date") {
+		t.Errorf("embed without a year has a release date:\n%s", body)
+	}
+
+	if body := get(r, "/title/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d").Body.String(); strings.Contains(body, "book:") {
+		t.Errorf("embed of a missing manga has book tags:\n%s", body)
+	}
+}
+
+func TestMalformedResponseCached(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	clock := newFakeClock()
+	dexClient.malformed = newCache(time.Minute, 100, clock)
+	const mangaId = "00000000-0000-4000-8000-000000000000"
+
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "ok", "data": {`))
+	})
+
+	for i := 0; i < 3; i++ {
+		if w := get(r, "/title/"+mangaId); w.Code == http.StatusOK {
+			t.Fatalf("status of a malformed response = %d, want an error", w.Code)
+		}
+	}
+	if n := fixtures.count("/manga/" + mangaId); n != 1 {
+		t.Errorf("malformed manga requested %d times within the ttl, want 1", n)
+	}
+
+	clock.Advance(time.Minute)
+	get(r, "/title/"+mangaId)
+	if n := fixtures.count("/manga/" + mangaId); n != 2 {
+		t.Errorf("malformed manga requested %d times after the ttl, want 2", n)
+	}
+
+	// Missing manga are valid responses and not cached as malformed
+	for i := 0; i < 2; i++ {
+		get(r, "/title/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d")
+	}
+	if n := fixtures.count("/manga/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d"); n != 2 {
+		t.Errorf("missing manga requested %d times, want 2", n)
+	}
+}
+
+func TestEmptyRelationshipIds(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const mangaId = "00000000-0000-4000-8000-000000000000"
+
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"result": "ok", "data": {"id": "` + mangaId + `", "type": "manga", "attributes": {"title": {"en": "Missing Ids"}}, "relationships": [
+			{"type": "author"},
+			{"id": "", "type": "artist"},
+			{"type": "cover_art"},
+			{"id": "9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d", "type": "author"}
+		]}}`))
+	})
+
+	before := emptyRelationshipIds.Value()
+	w := get(r, "/title/"+mangaId)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`<meta content="Missing Ids - Azuma Kiyohiko" property="og:title">`,
+		`<meta content="https://example.com/fallback.png" property='og:image'>`,
+	)
+
+	if n := emptyRelationshipIds.Value() - before; n != 3 {
+		t.Errorf("empty_relationship_ids increased by %d, want 3", n)
+	}
+	for _, path := range []string{"/author/", "/cover/"} {
+		if n := fixtures.count(path); n != 0 {
+			t.Errorf("%s requested %d times, want 0", path, n)
+		}
+	}
+}
+
+func TestFollowedRelationships(t *testing.T) {
+	rel := fastjson.MustParse(`[
+		{"id": "a1", "type": "author"},
+		{"id": "a9", "type": "artist"},
+		{"id": "a2", "type": "author"},
+		{"id": "c1", "type": "cover_art"},
+		{"id": "c2", "type": "cover_art"},
+		{"id": "a1", "type": "artist"},
+		{"id": "a3", "type": "author"},
+		{"type": "author"}
+	]`).GetArray()
+	included := fastjson.MustParse(`[
+		{"id": "c1", "type": "cover_art", "attributes": {"fileName": "cover.jpg"}},
+		{"id": "a1", "type": "author"},
+		{"id": "a2", "type": "author"}
+	]`).GetArray()
+
+	tests := []struct {
+		rel  []*fastjson.Value
+		max  int
+		want []bool
+	}{
+		{rel, 0, []bool{true, true, true, true, false, false, true, false}},
+		{rel, 2, []bool{true, false, false, true, false, false, false, false}},
+		{rel, 3, []bool{true, false, true, true, false, false, false, false}},
+		{rel, 5, []bool{true, true, true, true, false, false, true, false}},
+		// Included covers are not looked up and do not count
+		{included, 2, []bool{true, true, true}},
+	}
+
+	for _, tt := range tests {
+		if got := followedRelationships(tt.rel, tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("followedRelationships(%v, %d) = %v, want %v", tt.rel, tt.max, got, tt.want)
+		}
+	}
+}
+
+func TestMaxRelationships(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.MaxRelationships = 3
+	})
+	const mangaId = "00000000-0000-4000-8000-000000000000"
+
+	var rel []string
+	var authors []string
+	for i := 0; i < 12; i++ {
+		id := fmt.Sprintf("00000000-0000-4000-8000-1%011d", i)
+		authors = append(authors, id)
+		rel = append(rel, fmt.Sprintf(`{"id": %q, "type": "author"}`, id))
+	}
+	rel = append(rel, `{"id": "b3c4d5e6-f7a8-4b9c-8d0e-1f2a3b4c5d6e", "type": "cover_art"}`)
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"result": "ok", "data": {"id": %q, "type": "manga", "attributes": {"title": {"en": "Anthology"}}, "relationships": [%s]}}`, mangaId, strings.Join(rel, ","))
+	})
+
+	w := get(r, "/title/"+mangaId)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(), "8e7f9d3c-0a4b-4c5d-9e8f-3a4b5c6d7e8f.png")
+
+	requested := 0
+	for i, id := range authors {
+		if n := fixtures.count("/author/" + id); n > 0 {
+			requested++
+			if i >= 2 {
+				t.Errorf("author %d requested before the first ones", i)
+			}
+		}
+	}
+	if requested != 2 {
+		t.Errorf("%d authors requested, want 2 besides the cover", requested)
+	}
+}
+
+func TestCanonicalLink(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.NoEmbedIds = map[string]bool{"2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f": true}
+	})
+
+	tests := []struct {
+		path string
+		id   string
+	}{
+		{"/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"},
+		{"/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/yotsuba", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"},
+		{"/manga/7F3C1B2E-5D4A-4C8E-9A6B-1E2F3A4B5C6D", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"},
+		{"/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f", "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f"},
+	}
+
+	for _, tt := range tests {
+		w := get(r, tt.path)
+		canonical := "https://mangadex.org/title/" + tt.id
+		if link := w.Header().Get("Link"); link != `<`+canonical+`>; rel="canonical"` {
+			t.Errorf("Link of %s = %q, want the canonical url %s", tt.path, link, canonical)
+		}
+		assertContains(t, w.Body.String(), `<link href="`+canonical+`" rel="canonical">`)
+	}
+}
+
+func TestNewServerTimeouts(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.ReadHeaderTimeout = 50 * time.Millisecond
+		c.ReadTimeout = 2 * time.Second
+		c.WriteTimeout = 3 * time.Second
+		c.IdleTimeout = 4 * time.Second
+	})
+
+	srv := newServer(":8080", r)
+	if srv.Addr != ":8080" || srv.Handler != r {
+		t.Errorf("server listens on %q with %v", srv.Addr, srv.Handler)
+	}
+	if srv.ReadHeaderTimeout != 50*time.Millisecond || srv.ReadTimeout != 2*time.Second || srv.WriteTimeout != 3*time.Second || srv.IdleTimeout != 4*time.Second {
+		t.Errorf("server timeouts = %v, %v, %v, %v", srv.ReadHeaderTimeout, srv.ReadTimeout, srv.WriteTimeout, srv.IdleTimeout)
+	}
+
+	// A client that does not finish its headers is disconnected
+	ts := httptest.NewUnstartedServer(r)
+	ts.Config = newServer("", r)
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("GET /ready HTTP/1.1\r\nHost: example.com\r\n"))
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	start := time.Now()
+	if _, err := io.ReadAll(conn); err != nil {
+		t.Fatalf("slow client was not disconnected: %v", err)
+	}
+	if d := time.Since(start); d > 2*time.Second {
+		t.Errorf("slow client disconnected after %v, want about 50ms", d)
+	}
+}
+
+func TestFirstCover(t *testing.T) {
+	tests := []struct {
+		rel  string
+		want int
+	}{
+		{`[{"id": "a1", "type": "author"}]`, -1},
+		{`[{"id": "a1", "type": "author"}, {"id": "c1", "type": "cover_art"}, {"id": "c2", "type": "cover_art"}]`, 1},
+		{`[{"type": "cover_art"}, {"id": "c2", "type": "cover_art"}]`, 1},
+		{`[{"type": "cover_art"}, {"type": "cover_art", "attributes": {"fileName": "cover.jpg"}}]`, 1},
+		{`[{"type": "cover_art"}, {"type": "cover_art"}]`, 0},
+	}
+
+	for _, tt := range tests {
+		if got := firstCover(fastjson.MustParse(tt.rel).GetArray()); got != tt.want {
+			t.Errorf("firstCover(%s) = %d, want %d", tt.rel, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedDuplicateCovers(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const mangaId = "00000000-0000-4000-8000-000000000000"
+
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"result": "ok", "data": {"id": %q, "type": "manga", "attributes": {"title": {"en": "Two Covers"}}, "relationships": [
+			{"type": "cover_art"},
+			{"id": "b3c4d5e6-f7a8-4b9c-8d0e-1f2a3b4c5d6e", "type": "cover_art"},
+			{"id": "c1d2e3f4-a5b6-4c7d-8e9f-0a1b2c3d4e5f", "type": "cover_art", "attributes": {"volume": "15", "fileName": "5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"}}
+		]}}`, mangaId)
+	})
+
+	for i := 0; i < 10; i++ {
+		body := get(r, "/title/"+mangaId).Body.String()
+		assertContains(t, body, fixtures.URL+"/covers/"+mangaId+"/8e7f9d3c-0a4b-4c5d-9e8f-3a4b5c6d7e8f.png")
+		if strings.Contains(body, "5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg") {
+			t.Fatalf("embed %d has a later cover:\n%s", i, body)
+		}
+	}
+	if n := fixtures.count("/cover/b3c4d5e6-f7a8-4b9c-8d0e-1f2a3b4c5d6e"); n != 10 {
+		t.Errorf("first cover requested %d times, want 10", n)
+	}
+}
+
+func TestMirrorFailover(t *testing.T) {
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+	const mangaPath = "/manga/" + mangaId
+	unavailable := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	slow := func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	missing := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte(`{"result":"error","errors":[{"status":404,"title":"Not found"}]}`))
+	}
+
+	tests := []struct {
+		name    string
+		primary http.HandlerFunc
+		status  int
+		mirror  int
+	}{
+		{"unavailable", unavailable, http.StatusOK, 1},
+		{"timeout", slow, http.StatusOK, 1},
+		{"not found", missing, http.StatusNotFound, 0},
+	}
+
+	for _, tt := range tests {
+		primary := newFixtureServer(t)
+		primary.handle(mangaPath, tt.primary)
+		r, mirror := newTestService(t, func(c *Config) {
+			c.ApiMirrors = []string{c.ApiUrl}
+			c.ApiUrl = primary.URL
+			c.MirrorTimeout = 50 * time.Millisecond
+		})
+
+		w := get(r, "/title/"+mangaId)
+		if w.Code != tt.status {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.status)
+		}
+		if tt.status == http.StatusOK {
+			assertContains(t, w.Body.String(), "Yotsuba&amp;!")
+		}
+		if n := primary.count(mangaPath); n != 1 {
+			t.Errorf("%s: primary requested %d times, want 1", tt.name, n)
+		}
+		if n := mirror.count(mangaPath); n != tt.mirror {
+			t.Errorf("%s: mirror requested %d times, want %d", tt.name, n, tt.mirror)
+		}
+	}
+}
+
+func TestMirrorBreakers(t *testing.T) {
+	const mangaPath = "/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+	primary := newFixtureServer(t)
+	primary.handle(mangaPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	r, mirror := newTestService(t, func(c *Config) {
+		c.ApiMirrors = []string{c.ApiUrl}
+		c.ApiUrl = primary.URL
+	})
+	clock := newFakeClock()
+	dexClient.breakers = newBreakerSet([]string{mangaEndpoint, coverEndpoint}, 1, time.Minute, clock)
+
+	for i := 0; i < 3; i++ {
+		if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusOK {
+			t.Errorf("status of embed %d = %d, want 200", i, w.Code)
+		}
+	}
+
+	// The breaker of the primary opened on its first failure, the one of the
+	// mirror stays closed
+	if n := primary.count(mangaPath); n != 1 {
+		t.Errorf("primary requested %d times, want 1", n)
+	}
+	if n := mirror.count(mangaPath); n != 3 {
+		t.Errorf("mirror requested %d times, want 3", n)
+	}
+	if dexClient.breakers.get(mirror.URL, mangaEndpoint).open() {
+		t.Error("breaker of the mirror is open")
+	}
+
+	clock.Advance(time.Minute)
+	get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if n := primary.count(mangaPath); n != 2 {
+		t.Errorf("primary requested %d times after the cooldown, want 2", n)
+	}
+
+	// Once every base failed the embed fails
+	mirror.handle(mangaPath, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	})
+	if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code < 500 {
+		t.Errorf("status with every base failing = %d, want 5xx", w.Code)
+	}
+}
+
+// gatedRender wraps the renders of embed.html, counting how many run at the
+// same time and holding each until gate returns.
+type gatedRender struct {
+	render render.HTMLRender
+	gate   func()
+
+	mu     sync.Mutex
+	active int
+	max    int
+}
+
+func (g *gatedRender) Instance(name string, data interface{}) render.Render {
+	page := g.render.Instance(name, data)
+	if name != "embed.html" {
+		return page
+	}
+	return gatedPage{page, g}
+}
+
+type gatedPage struct {
+	page render.Render
+	g    *gatedRender
+}
+
+func (p gatedPage) Render(w http.ResponseWriter) error {
+	p.g.mu.Lock()
+	p.g.active++
+	if p.g.active > p.g.max {
+		p.g.max = p.g.active
+	}
+	p.g.mu.Unlock()
+
+	p.g.gate()
+
+	p.g.mu.Lock()
+	p.g.active--
+	p.g.mu.Unlock()
+	return p.page.Render(w)
+}
+
+func (p gatedPage) WriteContentType(w http.ResponseWriter) {
+	p.page.WriteContentType(w)
+}
+
+func TestRenderConcurrency(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.RenderConcurrency = 2
+		c.RenderQueueTimeout = 5 * time.Second
+	})
+	g := &gatedRender{render: r.HTMLRender, gate: func() { time.Sleep(10 * time.Millisecond) }}
+	r.HTMLRender = g
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusOK {
+				t.Errorf("status = %d, want 200", w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if g.max > 2 {
+		t.Errorf("%d embeds rendered at the same time, want at most 2", g.max)
+	}
+}
+
+func TestRenderQueueTimeout(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.RenderConcurrency = 1
+		c.RenderQueueTimeout = 20 * time.Millisecond
+	})
+	release := make(chan struct{})
+	g := &gatedRender{render: r.HTMLRender, gate: func() { <-release }}
+	r.HTMLRender = g
+
+	done := make(chan int)
+	go func() {
+		done <- get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d").Code
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for {
+		g.mu.Lock()
+		active := g.active
+		g.mu.Unlock()
+		if active == 1 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	before := renderRejections.Value()
+	w := get(r, "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("status with no render slot = %d, want 503", w.Code)
+	}
+	if got := w.Header().Get("Retry-After"); got != "1" {
+		t.Errorf("Retry-After = %q, want %q", got, "1")
+	}
+	if got := renderRejections.Value() - before; got != 1 {
+		t.Errorf("render_rejections increased by %d, want 1", got)
+	}
+
+	close(release)
+	if code := <-done; code != http.StatusOK {
+		t.Errorf("status of the held render = %d, want 200", code)
+	}
+
+	// Without a limit nothing waits
+	r, _ = newTestService(t, nil)
+	if renderSem != nil {
+		t.Error("renders are limited with RENDER_CONCURRENCY unset")
+	}
+	if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusOK {
+		t.Errorf("status without a limit = %d, want 200", w.Code)
+	}
+}
+
+func TestTagDescription(t *testing.T) {
+	genre := func(name string) string {
+		return `{"type": "tag", "attributes": {"name": {"en": "` + name + `"}, "group": "genre"}}`
+	}
+	theme := `{"type": "tag", "attributes": {"name": {"en": "Isekai"}, "group": "theme"}}`
+
+	tests := []struct {
+		attr string
+		want string
+	}{
+		{`{"tags": [` + genre("Action") + `,` + genre("Fantasy") + `], "status": "ongoing", "publicationDemographic": "seinen", "originalLanguage": "ja"}`, "Action, Fantasy — Ongoing seinen manga"},
+		{`{"tags": [` + genre("Action") + `,` + theme + `], "status": "completed", "originalLanguage": "ko"}`, "Action — Completed manhwa"},
+		{`{"tags": [` + genre("Romance") + `], "originalLanguage": "zh-hk"}`, "Romance — Manhua"},
+		{`{"tags": [` + genre("A") + `,` + genre("B") + `,` + genre("C") + `,` + genre("D") + `], "status": "hiatus"}`, "A, B, C — Hiatus manga"},
+		{`{"tags": [` + theme + `], "publicationDemographic": "shoujo"}`, "Shoujo manga"},
+		{`{"tags": [` + theme + `]}`, ""},
+		{`{}`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := tagDescription(fastjson.MustParse(tt.attr)); got != tt.want {
+			t.Errorf("tagDescription(%s) = %q, want %q", tt.attr, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedTagDescription(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.DescriptionFallback = "tags"
+	})
+
+	// The Tower Climber has no description
+	w := get(r, "/title/5e7a9c1b-3d5f-4b7a-9c1e-3f5a7c9e1b3d")
+	assertContains(t, w.Body.String(), `Action, Fantasy — Ongoing manhwa" property="og:description"`)
+
+	// A description is used as it is
+	w = get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	assertContains(t, w.Body.String(), `Yotsuba is a strange little girl with a big heart." property="og:description"`)
+}
+
+func TestEmbedNonStringTitle(t *testing.T) {
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		title     string
+		altTitles string
+		want      string
+		warning   string
+	}{
+		{`{"en": 5, "fr": "Yotsuba et !"}`, "", `<meta content="Yotsuba et ! - Azuma Kiyohiko" property="og:title">`, `value of language "en" has type number instead of string`},
+		{`{"en": null}`, "", `<meta content="よつばと！ - Azuma Kiyohiko" property="og:title">`, ""},
+		{`{"en": ["Yotsuba&!"]}`, "", `<meta content="よつばと！ - Azuma Kiyohiko" property="og:title">`, `value of language "en" has type array instead of string`},
+		{`{"en": {"text": "Yotsuba&!"}}`, `[{"ja": false}]`, `property="og:title"`, "manga " + mangaId + " has no title"},
+	}
+
+	for _, tt := range tests {
+		v := fastjson.MustParseBytes(fixture)
+		v.Get("data", "attributes").Set("title", fastjson.MustParse(tt.title))
+		if tt.altTitles != "" {
+			v.Get("data", "attributes").Set("altTitles", fastjson.MustParse(tt.altTitles))
+		}
+		body := v.MarshalTo(nil)
+
+		r, fixtures := newTestService(t, nil)
+		fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(body)
+		})
+		var logs bytes.Buffer
+		gin.DefaultWriter = &logs
+
+		w := get(r, "/title/"+mangaId)
+		if w.Code != http.StatusOK {
+			t.Errorf("status with title %s = %d, want 200", tt.title, w.Code)
+		}
+		assertContains(t, w.Body.String(), tt.want)
+		if tt.warning != "" {
+			assertContains(t, logs.String(), tt.warning)
+		} else if strings.Contains(logs.String(), "[WARNING]") {
+			t.Errorf("warning logged for title %s:\n%s", tt.title, logs.String())
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"sync/atomic"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenance is 1 while every embed is replaced by the maintenance page
+var maintenance int32
+
+func setMaintenance(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&maintenance, v)
+}
+
+func inMaintenance() bool {
+	return atomic.LoadInt32(&maintenance) == 1
+}
+
+// maintenanceExempt are the path prefixes still served during maintenance
+var maintenanceExempt = []string{"/stats", "/ready", "/static/", "/warm", "/maintenance", "/ratelimit"}
+
+// maintenanceMode short-circuits requests with the maintenance page and 503,
+// so links still unfurl to something while the service is being worked on.
+func maintenanceMode(c *gin.Context) {
+	if !inMaintenance() {
+		c.Next()
+		return
+	}
+
+	for _, prefix := range maintenanceExempt {
+		if strings.HasPrefix(c.Request.URL.Path, config.BasePath+prefix) {
+			c.Next()
+			return
+		}
+	}
+
+	c.Header("Retry-After", "300")
+	renderError(c, http.StatusServiceUnavailable, config.MaintenanceMessage)
+}
+
+type maintenanceRequest struct {
+	Enabled *bool `json:"enabled" binding:"required"`
+}
+
+// toggleMaintenance turns maintenance mode on or off with {"enabled": bool}.
+func toggleMaintenance(c *gin.Context) {
+	var req maintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+
+	setMaintenance(*req.Enabled)
+	c.JSON(http.StatusOK, gin.H{"enabled": inMaintenance()})
+}
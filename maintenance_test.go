@@ -0,0 +1,55 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenanceToggle(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.AdminToken = "secret"
+	})
+	t.Cleanup(func() { setMaintenance(false) })
+	const path = "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	toggle := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/maintenance", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := toggle(`{"enabled": true}`); w.Code != http.StatusOK || w.Body.String() != `{"enabled":true}` {
+		t.Fatalf("enabling = %d %s", w.Code, w.Body)
+	}
+
+	w := get(r, path)
+	if w.Code != http.StatusServiceUnavailable {
+		t.Fatalf("status during maintenance = %d, want 503", w.Code)
+	}
+	if w.Header().Get("Retry-After") == "" {
+		t.Error("no Retry-After during maintenance")
+	}
+	assertContains(t, w.Body.String(), config.MaintenanceMessage)
+
+	for _, exempt := range []string{"/stats", "/ready"} {
+		if w := get(r, exempt); w.Code != http.StatusOK {
+			t.Errorf("status of %s during maintenance = %d, want 200", exempt, w.Code)
+		}
+	}
+
+	if w := toggle(`{}`); w.Code != http.StatusBadRequest || !inMaintenance() {
+		t.Errorf("toggle without enabled = %d, maintenance %t, want 400 and unchanged", w.Code, inMaintenance())
+	}
+
+	if w := toggle(`{"enabled": false}`); w.Code != http.StatusOK || w.Body.String() != `{"enabled":false}` {
+		t.Fatalf("disabling = %d %s", w.Code, w.Body)
+	}
+	if w := get(r, path); w.Code != http.StatusOK {
+		t.Errorf("status after maintenance = %d, want 200", w.Code)
+	}
+}
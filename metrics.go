@@ -0,0 +1,65 @@
+package main
+
+import (
+	"expvar"
+	"sync/atomic"
+)
+
+// Metrics are published through expvar and served on /stats.
+var (
+	// Requests not sent because of the rate limiter
+	limiterErrors = expvar.NewInt("limiter_errors")
+	// Requests not sent because the circuit breaker of the endpoint is open
+	breakerRejections = expvar.NewInt("breaker_rejections")
+	// Requests to MangaDex not made because the embed used up MAX_UPSTREAM_CALLS
+	callBudgetExceeded = expvar.NewInt("call_budget_exceeded")
+	// Requests to mirrors not made because the embed used up RETRY_BUDGET
+	retryBudgetExceeded = expvar.NewInt("retry_budget_exceeded")
+	// Relationships of manga without an id, which are not looked up
+	emptyRelationshipIds = expvar.NewInt("empty_relationship_ids")
+	// Covers not proxied because COVER_PROXY_CONCURRENCY were already streaming
+	coverProxyRejections = expvar.NewInt("cover_proxy_rejections")
+	// Embeds not rendered because no render slot freed up in time
+	renderRejections = expvar.NewInt("render_rejections")
+	// Requests not handled because the request queue was full or the client
+	// gave up waiting
+	queueRejections = expvar.NewInt("queue_rejections")
+	// Embed events dropped because the webhook queue was full, and posts
+	// to the webhook that failed
+	webhookDropped = expvar.NewInt("webhook_dropped")
+	webhookErrors  = expvar.NewInt("webhook_errors")
+)
+
+func init() {
+	expvar.Publish("limiter_waiting", expvar.Func(func() interface{} {
+		if dexClient == nil {
+			return 0
+		}
+		return atomic.LoadInt64(&dexClient.waiting)
+	}))
+
+	expvar.Publish("rate_limiter", expvar.Func(func() interface{} {
+		if dexClient == nil {
+			return nil
+		}
+		return dexClient.limits()
+	}))
+
+	// Hits and misses are read together, so the ratio matches the counts
+	expvar.Publish("cache", expvar.Func(func() interface{} {
+		if dexClient == nil || dexClient.cache == nil {
+			return nil
+		}
+
+		hits, misses := dexClient.cache.Stats()
+		ratio := 0.0
+		if hits+misses > 0 {
+			ratio = float64(hits) / float64(hits+misses)
+		}
+		return map[string]interface{}{
+			"hits":      hits,
+			"misses":    misses,
+			"hit_ratio": ratio,
+		}
+	}))
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestCacheMetrics(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.CacheTTL = time.Minute
+	})
+
+	stats := func() map[string]float64 {
+		var s struct {
+			Cache map[string]float64 `json:"cache"`
+		}
+		if err := json.Unmarshal(get(r, "/stats").Body.Bytes(), &s); err != nil {
+			t.Fatal(err)
+		}
+		return s.Cache
+	}
+
+	if s := stats(); s["hits"] != 0 || s["misses"] != 0 || s["hit_ratio"] != 0 {
+		t.Errorf("cache stats before requests = %v, want none", s)
+	}
+
+	// The manga and the author miss, then hit
+	for i := 0; i < 4; i++ {
+		get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	}
+	if s := stats(); s["hits"] != 6 || s["misses"] != 2 || s["hit_ratio"] != 0.75 {
+		t.Errorf("cache stats = %v, want 6 hits, 2 misses and a ratio of 0.75", s)
+	}
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"runtime/debug"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// requestIdHeader is read from and echoed to clients to correlate logs
+const requestIdHeader = "X-Request-Id"
+
+var validRequestId = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// requestId assigns every request an id, reusing the one sent by a proxy if valid.
+func requestId(c *gin.Context) {
+	id := c.GetHeader(requestIdHeader)
+	if !validRequestId.MatchString(id) {
+		b := make([]byte, 8)
+		rand.Read(b)
+		id = hex.EncodeToString(b)
+	}
+
+	c.Set("request_id", id)
+	c.Header(requestIdHeader, id)
+	c.Next()
+}
+
+// lowerIds lower cases the manga id of the route, MangaDex ids are
+// case-insensitive and this keeps the blocklists and cache keys consistent.
+func lowerIds(c *gin.Context) {
+	for i, p := range c.Params {
+		if p.Key == "md-id" || p.Key == "feed" {
+			c.Params[i].Value = strings.ToLower(p.Value)
+		}
+	}
+	c.Next()
+}
+
+// recovery logs a panic of a handler and renders the error page with 500,
+// so crawlers still get a valid page instead of an empty response.
+func recovery(c *gin.Context) {
+	defer func() {
+		err := recover()
+		if err == nil {
+			return
+		}
+		// The client went away, there is nothing to render
+		if err == http.ErrAbortHandler {
+			panic(err)
+		}
+
+		id := c.GetString("request_id")
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: panic in request %s: %v\n%s\n", id, err, debug.Stack())
+
+		if c.Writer.Written() {
+			c.Abort()
+			return
+		}
+		renderError(c, http.StatusInternalServerError, "")
+	}()
+
+	c.Next()
+}
+
+// limitBody rejects requests with a body larger than max bytes with 413.
+// The body is read up front, so handlers can not run into the limit halfway.
+func limitBody(max int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.Request.ContentLength > max {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(c.Request.Body, max+1))
+		if err != nil {
+			c.AbortWithStatus(http.StatusBadRequest)
+			return
+		}
+		if int64(len(body)) > max {
+			c.AbortWithStatus(http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+		c.Next()
+	}
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecovery(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	var logs bytes.Buffer
+	gin.DefaultWriter = &logs
+
+	r.GET("/panic", func(c *gin.Context) {
+		panic("handler failed")
+	})
+	r.GET("/panic-after-write", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		panic("handler failed")
+	})
+
+	w := get(r, "/panic", requestIdHeader, "abc123")
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`<meta content="Something went wrong" property="og:title">`,
+		`Request id: abc123`,
+	)
+	if !strings.Contains(logs.String(), "panic in request abc123: handler failed") {
+		t.Errorf("panic not logged with the request id:\n%s", logs.String())
+	}
+
+	// A response that was started is left as it is
+	w = get(r, "/panic-after-write")
+	if w.Code != http.StatusOK || w.Body.String() != "partial" {
+		t.Errorf("response after writing = %d %q, want 200 %q", w.Code, w.Body, "partial")
+	}
+
+	// The service keeps handling requests
+	if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); w.Code != http.StatusOK {
+		t.Errorf("status after a panic = %d, want 200", w.Code)
+	}
+}
+
+func TestRequestId(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	if id := get(r, "/", requestIdHeader, "proxy-id_1").Header().Get(requestIdHeader); id != "proxy-id_1" {
+		t.Errorf("%s = %q, want the id of the proxy", requestIdHeader, id)
+	}
+
+	a := get(r, "/", requestIdHeader, "not valid!").Header().Get(requestIdHeader)
+	b := get(r, "/").Header().Get(requestIdHeader)
+	if !validRequestId.MatchString(a) || a == "not valid!" || !validRequestId.MatchString(b) || a == b {
+		t.Errorf("generated ids = %q and %q, want two different valid ids", a, b)
+	}
+}
+
+func TestLimitBody(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.AdminToken = "secret"
+		c.CacheTTL = time.Minute
+		c.MaxBodyBytes = 64
+	})
+
+	small := `{"ids": ["7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"]}`
+	large := `{"ids": ["` + strings.Repeat("7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", 4) + `"]}`
+
+	tests := []struct {
+		name string
+		body io.Reader
+		want int
+	}{
+		{"small body", strings.NewReader(small), http.StatusOK},
+		{"large body", strings.NewReader(large), http.StatusRequestEntityTooLarge},
+		// Without a Content-Length the body is only found too large when read
+		{"large body of unknown length", io.MultiReader(strings.NewReader(large)), http.StatusRequestEntityTooLarge},
+	}
+
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/warm", tt.body)
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		if w.Code != tt.want {
+			t.Errorf("status with a %s = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestTrailingSlashAndCase(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.RedirectTrailingSlash = true
+		c.CacheTTL = time.Minute
+	})
+
+	w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/")
+	if w.Code != http.StatusMovedPermanently || w.Header().Get("Location") != "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d" {
+		t.Errorf("trailing slash = %d to %q, want a redirect without it", w.Code, w.Header().Get("Location"))
+	}
+
+	// Ids are looked up and cached in lower case
+	for _, path := range []string{
+		"/title/7F3C1B2E-5D4A-4C8E-9A6B-1E2F3A4B5C6D",
+		"/title/7f3c1b2e-5D4A-4c8e-9a6b-1e2f3a4b5c6d/Yotsuba",
+		"/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+	} {
+		w := get(r, path)
+		if w.Code != http.StatusOK {
+			t.Errorf("status of %s = %d, want 200", path, w.Code)
+		}
+		assertContains(t, w.Body.String(), `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`)
+	}
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); n != 1 {
+		t.Errorf("manga requested %d times, want 1", n)
+	}
+
+	r, _ = newTestService(t, func(c *Config) {
+		c.RedirectTrailingSlash = false
+	})
+	if w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/"); w.Code != http.StatusNotFound {
+		t.Errorf("trailing slash without redirects = %d, want 404", w.Code)
+	}
+}
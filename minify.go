@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/render"
+)
+
+var (
+	htmlComment = regexp.MustCompile(`(?s)<!--.*?-->`)
+	// Whitespace between tags on different lines, which only indents the
+	// template. Spaces on the same line may separate inline elements
+	tagIndent = regexp.MustCompile(`>\s*\n\s*<`)
+)
+
+// minifyHTML removes the comments and the indentation between tags of
+// rendered templates. Text and attributes are left as they are, including
+// the JSON-LD script, whose line breaks are escaped by html/template.
+func minifyHTML(b []byte) []byte {
+	b = htmlComment.ReplaceAll(b, nil)
+	b = tagIndent.ReplaceAll(b, []byte("><"))
+	return bytes.TrimSpace(b)
+}
+
+// minifiedHTML renders the templates of render like it, then minifies them.
+type minifiedHTML struct {
+	render render.HTMLRender
+}
+
+func (m minifiedHTML) Instance(name string, data interface{}) render.Render {
+	return minifiedPage{m.render.Instance(name, data)}
+}
+
+type minifiedPage struct {
+	page render.Render
+}
+
+func (p minifiedPage) Render(w http.ResponseWriter) error {
+	var buf bytes.Buffer
+	rec := &bufferedWriter{ResponseWriter: w, buf: &buf}
+	if err := p.page.Render(rec); err != nil {
+		return err
+	}
+
+	_, err := w.Write(minifyHTML(buf.Bytes()))
+	return err
+}
+
+func (p minifiedPage) WriteContentType(w http.ResponseWriter) {
+	p.page.WriteContentType(w)
+}
+
+// bufferedWriter collects the body of a response to change it before it
+// is written to the ResponseWriter.
+type bufferedWriter struct {
+	http.ResponseWriter
+	buf *bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+// useMinifiedHTML makes the engine minify its templates if MINIFY_HTML is
+// set. It must be called after the templates are set.
+func useMinifiedHTML(engine *gin.Engine) {
+	if config.MinifyHTML {
+		engine.HTMLRender = minifiedHTML{engine.HTMLRender}
+	}
+}
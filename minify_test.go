@@ -0,0 +1,58 @@
+package main
+
+import (
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestMinifyHTML(t *testing.T) {
+	tests := []struct {
+		html string
+		want string
+	}{
+		{"<html>\n    <head>\n        <meta content=\"a\">\n    </head>\n</html>\n", `<html><head><meta content="a"></head></html>`},
+		{"<p>\n  <!-- a comment\n  over lines -->\n  <b>bold</b> <i>italic</i>\n</p>", "<p><b>bold</b> <i>italic</i></p>"},
+		{`<meta content="two  spaces` + "\n" + `and a line" property="og:description">`, `<meta content="two  spaces` + "\n" + `and a line" property="og:description">`},
+		{"  text  ", "text"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := string(minifyHTML([]byte(tt.html))); got != tt.want {
+			t.Errorf("minifyHTML(%q) = %q, want %q", tt.html, got, tt.want)
+		}
+	}
+}
+
+// tag finds the tags of a page that crawlers read.
+var tag = regexp.MustCompile(`<(meta|link|script)[^>]*>`)
+
+func TestEmbedMinified(t *testing.T) {
+	const path = "/title/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f"
+
+	r, _ := newTestService(t, nil)
+	normal := get(r, path).Body.String()
+
+	// The same fixture server, so the cover urls are the same
+	config.MinifyHTML = true
+	r = newRouter(templates)
+	w := get(r, path)
+	minified := w.Body.String()
+
+	if len(minified) >= len(normal) {
+		t.Errorf("minified embed has %d bytes, the normal one %d", len(minified), len(normal))
+	}
+	if got, want := tag.FindAllString(minified, -1), tag.FindAllString(normal, -1); len(want) == 0 || !reflect.DeepEqual(got, want) {
+		t.Errorf("tags of the minified embed = %q, want %q", got, want)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q, want text/html", ct)
+	}
+	assertContains(t, minified, `<meta content="Death Note - Ohba Tsugumi, Obata Takeshi" property="og:title">`, jsonLDScript.FindString(normal))
+
+	// Error pages are minified too
+	if body := get(r, "/no/such/page").Body.String(); regexp.MustCompile(`>\s*\n\s*<`).MatchString(body) {
+		t.Errorf("error page is not minified:\n%s", body)
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"net/http"
+	"os"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	placeholderOnce sync.Once
+	placeholderBody []byte
+	placeholderType string
+)
+
+// coverPlaceholder returns the image served instead of hidden covers, the file
+// COVER_PLACEHOLDER or a plain grey image of the size of a cover.
+func coverPlaceholder() ([]byte, string) {
+	placeholderOnce.Do(func() {
+		if config.CoverPlaceholder != "" {
+			body, err := os.ReadFile(config.CoverPlaceholder)
+			if err == nil {
+				placeholderBody, placeholderType = body, http.DetectContentType(body)
+				return
+			}
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: could not read COVER_PLACEHOLDER: %v\n", err)
+		}
+
+		img := image.NewGray(image.Rect(0, 0, 512, 728))
+		draw.Draw(img, img.Bounds(), image.NewUniform(color.Gray{Y: 0x80}), image.Point{}, draw.Src)
+
+		var buf bytes.Buffer
+		png.Encode(&buf, img)
+		placeholderBody, placeholderType = buf.Bytes(), "image/png"
+	})
+	return placeholderBody, placeholderType
+}
+
+// hiddenCover reports whether the cover of the manga is replaced by the
+// placeholder because of its content rating. The cover is hidden as well
+// if the rating cannot be looked up.
+func hiddenCover(c *gin.Context, mangaId string) bool {
+	if len(config.HiddenCoverRatings) == 0 {
+		return false
+	}
+
+	mangaJSON, err := dexClient.RequestJSON(c.Request.Context(), mangaEndpoint, mangaId)
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: could not look up content rating: %v\n", err)
+		return true
+	}
+	return config.hidesCover(string(mangaJSON.Get("data", "attributes").GetStringBytes("contentRating")))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHiddenCover(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.HiddenCoverRatings = []string{"pornographic"}
+	})
+	const mangaId = "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+	const cover = "/cover/" + mangaId + "/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(bytes.Replace(fixture, []byte(`"contentRating": "safe"`), []byte(`"contentRating": "pornographic"`), 1))
+	})
+
+	w := get(r, cover)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "image/png" {
+		t.Errorf("Content-Type = %q, want image/png", ct)
+	}
+	img, err := png.Decode(w.Body)
+	if err != nil {
+		t.Fatalf("placeholder is not a png: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 512 || size.Y != 728 {
+		t.Errorf("placeholder size = %v, want 512x728", size)
+	}
+	if n := fixtures.count("/covers/" + mangaId + "/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"); n != 0 {
+		t.Errorf("hidden cover downloaded %d times, want 0", n)
+	}
+
+	// The embed links the proxy even if it is not enabled for all covers
+	assertContains(t, get(r, "/title/"+mangaId).Body.String(), `<meta content="http://example.com`+cover+`" property='og:image'>`)
+
+	w = get(r, "/cover/2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f/6d5f8c2b-9e3a-4f4b-8c7d-2e3f4a5b6c7d.jpg")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Body.String(), "cover of ") {
+		t.Errorf("safe cover = %d %q, want the cover", w.Code, w.Body)
+	}
+
+	// Covers whose rating is unknown are hidden as well
+	w = get(r, "/cover/0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg")
+	if w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("cover of an unknown manga = %d %q, want the placeholder", w.Code, w.Header().Get("Content-Type"))
+	}
+}
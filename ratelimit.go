@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// rateForQuota returns the rate that spreads the remaining MangaDex quota
+// over the time until it resets, between floor and base. The base rate is
+// used as long as more than lowQuota requests remain.
+func rateForQuota(remaining int, reset time.Duration, lowQuota int, base rate.Limit, floor rate.Limit) rate.Limit {
+	if remaining > lowQuota {
+		return base
+	}
+	if reset <= 0 {
+		return floor
+	}
+
+	r := rate.Limit(float64(remaining) / reset.Seconds())
+	if r < floor {
+		return floor
+	}
+	if r > base {
+		return base
+	}
+	return r
+}
+
+// adjustRate reads the X-RateLimit headers of a MangaDex response and,
+// if adaptive rate limiting is enabled, slows down the limiter when the
+// remaining quota is low.
+func (c *RateLimitedClient) adjustRate(header http.Header) {
+	remaining, err := strconv.Atoi(header.Get("X-RateLimit-Remaining"))
+	if err != nil {
+		return
+	}
+
+	c.mu.RLock()
+	adaptive, lowQuota, base, floor := c.Adaptive, c.LowQuota, c.BaseRate, c.FloorRate
+	c.mu.RUnlock()
+
+	var reset time.Duration
+	if retryAfter, err := strconv.ParseInt(header.Get("X-RateLimit-Retry-After"), 10, 64); err == nil {
+		reset = time.Unix(retryAfter, 0).Sub(c.clock.Now())
+	}
+
+	if remaining <= lowQuota {
+		fmt.Fprintf(gin.DefaultWriter, "[WARNING]: MangaDex quota low: %d of %s remaining, resets in %s\n",
+			remaining, header.Get("X-RateLimit-Limit"), reset.Round(time.Second))
+	}
+
+	if !adaptive {
+		return
+	}
+
+	limit := rateForQuota(remaining, reset, lowQuota, base, floor)
+	if limit != c.Ratelimiter.Limit() {
+		c.Ratelimiter.SetLimitAt(c.clock.Now(), limit)
+	}
+}
+
+// rateLimits is the rate limiter setting shown and changed by /ratelimit.
+// Rate is the configured rate, Current the one in use, which is lower
+// while adaptive rate limiting slows down.
+type rateLimits struct {
+	Rate    float64 `json:"rate"`
+	Current float64 `json:"current_rate"`
+	Burst   int     `json:"burst"`
+}
+
+// limits returns the current rate limiter setting.
+func (c *RateLimitedClient) limits() rateLimits {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return rateLimits{
+		Rate:    float64(c.BaseRate),
+		Current: float64(c.Ratelimiter.Limit()),
+		Burst:   c.Ratelimiter.Burst(),
+	}
+}
+
+// setLimits changes the rate and burst of the rate limiter, requests
+// already waiting keep their reservation.
+func (c *RateLimitedClient) setLimits(limit rate.Limit, burst int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.BaseRate = limit
+	now := c.clock.Now()
+	c.Ratelimiter.SetLimitAt(now, limit)
+	c.Ratelimiter.SetBurstAt(now, burst)
+}
+
+type rateLimitRequest struct {
+	Rate  *float64 `json:"rate"`
+	Burst *int     `json:"burst"`
+}
+
+// showRateLimit responds with the current rate limiter setting.
+func showRateLimit(c *gin.Context) {
+	c.JSON(http.StatusOK, dexClient.limits())
+}
+
+// tuneRateLimit changes the rate and burst of the rate limiter with
+// {"rate": float, "burst": int}, a missing value is left as it is.
+func tuneRateLimit(c *gin.Context) {
+	var req rateLimitRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.String(http.StatusBadRequest, "invalid request: %v", err)
+		return
+	}
+	if req.Rate == nil && req.Burst == nil {
+		c.String(http.StatusBadRequest, "invalid request: rate or burst is required")
+		return
+	}
+
+	current := dexClient.limits()
+	limit, burst := current.Rate, current.Burst
+	if req.Rate != nil {
+		limit = *req.Rate
+	}
+	if req.Burst != nil {
+		burst = *req.Burst
+	}
+
+	if !(limit > 0) || math.IsInf(limit, 0) {
+		c.String(http.StatusBadRequest, "invalid request: rate must be a positive number")
+		return
+	}
+	if burst < 1 {
+		c.String(http.StatusBadRequest, "invalid request: burst must be at least 1")
+		return
+	}
+
+	dexClient.setLimits(rate.Limit(limit), burst)
+	fmt.Fprintf(gin.DefaultWriter, "[INFO]: rate limit set to %v per second, burst %d\n", limit, burst)
+	c.JSON(http.StatusOK, dexClient.limits())
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateForQuota(t *testing.T) {
+	tests := []struct {
+		remaining int
+		reset     time.Duration
+		want      rate.Limit
+	}{
+		{100, time.Minute, 5},
+		{11, time.Minute, 5},
+		{10, time.Minute, rate.Limit(10.0 / 60)},
+		{6, 10 * time.Second, 0.6},
+		{1, time.Minute, 0.1},
+		{10, time.Second, 5},
+		{0, time.Minute, 0.1},
+		{6, 0, 0.1},
+	}
+
+	for _, tt := range tests {
+		if got := rateForQuota(tt.remaining, tt.reset, 10, 5, 0.1); got != tt.want {
+			t.Errorf("rateForQuota(%d, %s) = %v, want %v", tt.remaining, tt.reset, got, tt.want)
+		}
+	}
+}
+
+func TestAdjustRate(t *testing.T) {
+	clock := newFakeClock()
+	client := newRLClient(rate.NewLimiter(5, 1), clock)
+	client.Adaptive = true
+	client.LowQuota = 10
+	client.FloorRate = 0.1
+
+	header := func(remaining int, reset time.Duration) http.Header {
+		h := http.Header{}
+		h.Set("X-RateLimit-Limit", "40")
+		h.Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+		h.Set("X-RateLimit-Retry-After", strconv.FormatInt(clock.Now().Add(reset).Unix(), 10))
+		return h
+	}
+
+	client.adjustRate(header(30, time.Minute))
+	if l := client.Ratelimiter.Limit(); l != 5 {
+		t.Errorf("limit with a high quota = %v, want 5", l)
+	}
+
+	client.adjustRate(header(5, 10*time.Second))
+	if l := client.Ratelimiter.Limit(); l != 0.5 {
+		t.Errorf("limit with 5 requests left for 10s = %v, want 0.5", l)
+	}
+
+	client.adjustRate(header(0, time.Minute))
+	if l := client.Ratelimiter.Limit(); l != 0.1 {
+		t.Errorf("limit without quota = %v, want the floor 0.1", l)
+	}
+
+	// Once the quota reset the base rate is restored
+	client.adjustRate(header(40, time.Minute))
+	if l := client.Ratelimiter.Limit(); l != 5 {
+		t.Errorf("limit after the reset = %v, want 5", l)
+	}
+
+	// Responses without the headers leave the limiter alone
+	client.adjustRate(header(0, time.Minute))
+	client.adjustRate(http.Header{})
+	if l := client.Ratelimiter.Limit(); l != 0.1 {
+		t.Errorf("limit after a response without headers = %v, want 0.1", l)
+	}
+
+	client.Adaptive = false
+	client.adjustRate(header(40, time.Minute))
+	if l := client.Ratelimiter.Limit(); l != 0.1 {
+		t.Errorf("limit changed to %v without adaptive rate limiting", l)
+	}
+}
+
+func TestRateLimitEndpoint(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.AdminToken = "secret"
+		c.RateLimit = 2
+		c.RateBurst = 5
+	})
+
+	request := func(method string, body string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/ratelimit", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w
+	}
+
+	if w := request(http.MethodGet, "", "secret"); w.Code != http.StatusOK || w.Body.String() != `{"rate":2,"current_rate":2,"burst":5}` {
+		t.Errorf("GET /ratelimit = %d %s", w.Code, w.Body)
+	}
+	for _, method := range []string{http.MethodGet, http.MethodPost} {
+		if w := request(method, `{"rate": 10}`, "wrong"); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s /ratelimit with a wrong token = %d, want 401", method, w.Code)
+		}
+	}
+
+	tests := []struct {
+		body  string
+		want  int
+		rate  float64
+		burst int
+		name  string
+	}{
+		{`{"rate": 4}`, http.StatusOK, 4, 5, "a new rate"},
+		{`{"burst": 10}`, http.StatusOK, 4, 10, "a new burst"},
+		{`{"rate": 1.5, "burst": 3}`, http.StatusOK, 1.5, 3, "both"},
+		{`{}`, http.StatusBadRequest, 1.5, 3, "nothing"},
+		{`{"rate": 0}`, http.StatusBadRequest, 1.5, 3, "a zero rate"},
+		{`{"rate": -1}`, http.StatusBadRequest, 1.5, 3, "a negative rate"},
+		{`{"burst": 0}`, http.StatusBadRequest, 1.5, 3, "a zero burst"},
+		{`{"rate": "fast"}`, http.StatusBadRequest, 1.5, 3, "a string rate"},
+	}
+
+	for _, tt := range tests {
+		if w := request(http.MethodPost, tt.body, "secret"); w.Code != tt.want {
+			t.Errorf("POST /ratelimit with %s = %d %s, want %d", tt.name, w.Code, w.Body, tt.want)
+		}
+		if l := dexClient.limits(); l.Rate != tt.rate || l.Current != tt.rate || l.Burst != tt.burst {
+			t.Errorf("limits after %s = %+v, want rate %v burst %d", tt.name, l, tt.rate, tt.burst)
+		}
+	}
+
+	// The new setting is shown in /stats
+	assertContains(t, get(r, "/stats").Body.String(), `"rate_limiter": {"rate":1.5,"current_rate":1.5,"burst":3}`)
+
+	// The limiter can still be read and tuned during maintenance
+	setMaintenance(true)
+	t.Cleanup(func() { setMaintenance(false) })
+	if w := request(http.MethodGet, "", "secret"); w.Code != http.StatusOK || w.Body.String() != `{"rate":1.5,"current_rate":1.5,"burst":3}` {
+		t.Errorf("GET /ratelimit during maintenance = %d %s", w.Code, w.Body)
+	}
+	if w := request(http.MethodPost, `{"rate": 3}`, "secret"); w.Code != http.StatusOK {
+		t.Errorf("POST /ratelimit during maintenance = %d %s", w.Code, w.Body)
+	}
+	if l := dexClient.limits(); l.Rate != 3 {
+		t.Errorf("rate after POST during maintenance = %v, want 3", l.Rate)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// relatedManga is a sequel, prequel or other manga related to the embedded one.
+type relatedManga struct {
+	Title    string   `json:"title"`
+	Relation string   `json:"relation"`
+	Url      string   `json:"url"`
+	Cover    string   `json:"cover"`
+	Authors  []string `json:"authors"`
+}
+
+// relatedIds returns the ids and relation, e.g. "sequel", of at most max
+// related manga in the order of the relationships, leaving out blocked ones.
+func relatedIds(rel []*fastjson.Value, max int) ([]string, map[string]string) {
+	var ids []string
+	relations := make(map[string]string)
+	for _, v := range rel {
+		if len(ids) >= max {
+			break
+		}
+
+		id := strings.ToLower(string(v.GetStringBytes("id")))
+		if string(v.GetStringBytes("type")) != "manga" || !validId.MatchString(id) || config.isBlocked(id) {
+			continue
+		}
+		if _, ok := relations[id]; ok {
+			continue
+		}
+
+		ids = append(ids, id)
+		relations[id] = string(v.GetStringBytes("related"))
+	}
+	return ids, relations
+}
+
+// fetchRelated looks up the related manga in a single list request, which
+// includes their authors and covers, and returns them in the order of ids.
+// Manga missing from the response are left out.
+func fetchRelated(ctx context.Context, ids []string, relations map[string]string, langs []string) ([]relatedManga, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := url.Values{}
+	for _, id := range ids {
+		query.Add("ids[]", id)
+	}
+	query.Set("limit", strconv.Itoa(len(ids)))
+	query.Add("includes[]", "cover_art")
+	query.Add("includes[]", "author")
+
+	list, err := dexClient.RequestJSON(ctx, mangaListEndpoint, query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	byId := make(map[string]relatedManga)
+	for _, manga := range list.GetArray("data") {
+		id := strings.ToLower(string(manga.GetStringBytes("id")))
+		title, _ := localize(manga.Get("attributes").GetObject("title"), langs)
+		if config.SanitizeTitles {
+			title = sanitizeTitle(title)
+		}
+
+		var authors []string
+		for _, v := range manga.GetArray("relationships") {
+			if string(v.GetStringBytes("type")) != "author" {
+				continue
+			}
+			if name := string(v.Get("attributes").GetStringBytes("name")); name != "" {
+				authors = append(authors, name)
+			}
+		}
+
+		byId[id] = relatedManga{
+			Title:    title,
+			Relation: relations[id],
+			Url:      fmt.Sprintf("%s/title/%s", config.SiteUrl, id),
+			Cover:    inlineCover(manga),
+			Authors:  authors,
+		}
+	}
+
+	var related []relatedManga
+	for _, id := range ids {
+		if r, ok := byId[id]; ok {
+			related = append(related, r)
+		}
+	}
+	return related, nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestRelatedIds(t *testing.T) {
+	config = loadConfig()
+	rel := fastjson.MustParse(`[
+		{"id": "9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d", "type": "author"},
+		{"id": "2B8D4E6F-1A3C-4E5B-8D7F-9A0B1C2D3E4F", "type": "manga", "related": "sequel"},
+		{"id": "not-an-id", "type": "manga", "related": "prequel"},
+		{"id": "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f", "type": "manga", "related": "side_story"},
+		{"id": "4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a", "type": "manga", "related": "spin_off"},
+		{"id": "5e7a9c1b-3d5f-4b7a-9c1e-3f5a7c9e1b3d", "type": "manga", "related": "adapted_from"}
+	]`).GetArray()
+
+	ids, relations := relatedIds(rel, 2)
+	want := []string{"2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f", "4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a"}
+	if !reflect.DeepEqual(ids, want) {
+		t.Errorf("relatedIds ids = %q, want %q", ids, want)
+	}
+	if got := relations[want[0]]; got != "sequel" {
+		t.Errorf("relation of %s = %q, want %q", want[0], got, "sequel")
+	}
+	if got := relations[want[1]]; got != "spin_off" {
+		t.Errorf("relation of %s = %q, want %q", want[1], got, "spin_off")
+	}
+}
+
+func TestEmbedRelated(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.MaxRelated = 3
+	})
+
+	const mangaId = "00000000-0000-4000-8000-000000000000"
+	related := []string{
+		"00000000-0000-4000-8000-000000000001",
+		"00000000-0000-4000-8000-000000000002",
+		"00000000-0000-4000-8000-000000000003",
+	}
+
+	fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"result": "ok", "data": {"id": %q, "type": "manga", "attributes": {"title": {"en": "Main Series"}}, "relationships": [
+			{"id": %q, "type": "manga", "related": "sequel"},
+			{"id": %q, "type": "manga", "related": "prequel"},
+			{"id": %q, "type": "manga", "related": "side_story"}
+		]}}`, mangaId, related[0], related[1], related[2])
+	})
+
+	var query map[string][]string
+	fixtures.handle("/manga", func(w http.ResponseWriter, r *http.Request) {
+		query = r.URL.Query()
+
+		var data []string
+		// Listed in another order than requested, and without the last one
+		for _, id := range []string{related[1], related[0]} {
+			data = append(data, fmt.Sprintf(`{"id": %q, "type": "manga", "attributes": {"title": {"en": "Related %s"}}, "relationships": [
+				{"id": "9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d", "type": "author", "attributes": {"name": "Azuma Kiyohiko"}}
+			]}`, id, id[len(id)-1:]))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(w, `{"result": "ok", "data": [%s]}`, strings.Join(data, ","))
+	})
+
+	w := get(r, "/title/"+mangaId)
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /title/%s = %d, want %d", mangaId, w.Code, http.StatusOK)
+	}
+
+	if n := fixtures.count("/manga"); n != 1 {
+		t.Errorf("related manga looked up in %d requests, want 1", n)
+	}
+	if got := query["ids[]"]; !reflect.DeepEqual(got, related) {
+		t.Errorf("ids[] = %q, want %q", got, related)
+	}
+	if got := query["limit"]; !reflect.DeepEqual(got, []string{"3"}) {
+		t.Errorf("limit = %q, want %q", got, []string{"3"})
+	}
+	for _, id := range related {
+		if n := fixtures.count("/manga/" + id); n != 0 {
+			t.Errorf("related manga %s requested on its own %d times", id, n)
+		}
+	}
+
+	body := w.Body.String()
+	first := strings.Index(body, `<meta content="https://mangadex.org/title/`+related[0]+`" property="og:see_also">`)
+	second := strings.Index(body, `<meta content="https://mangadex.org/title/`+related[1]+`" property="og:see_also">`)
+	if first < 0 || second < first {
+		t.Errorf("related manga not linked in order:\n%s", body)
+	}
+	if strings.Contains(body, related[2]+`" property="og:see_also"`) {
+		t.Errorf("related manga missing from the response is linked:\n%s", body)
+	}
+}
+
+func TestEmbedRelatedDisabled(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if n := fixtures.count("/manga"); n != 0 {
+		t.Errorf("related manga looked up %d times with MAX_RELATED unset", n)
+	}
+}
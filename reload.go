@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"golang.org/x/time/rate"
+)
+
+// fileEnv are the variables set from CONFIG_FILE, which are updated on reload
+// while variables set in the environment itself take precedence.
+var fileEnv = map[string]bool{}
+
+// loadEnvFile sets the `KEY=value` lines of path as environment variables,
+// skipping empty lines and comments starting with #. Variables set by an
+// earlier load that path no longer sets are removed.
+func loadEnvFile(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	env := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return fmt.Errorf("invalid line in %s: %q", path, line)
+		}
+
+		env[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	for key := range fileEnv {
+		if _, set := env[key]; !set {
+			os.Unsetenv(key)
+			delete(fileEnv, key)
+		}
+	}
+	for key, value := range env {
+		if _, set := os.LookupEnv(key); set && !fileEnv[key] {
+			continue
+		}
+		fileEnv[key] = true
+		os.Setenv(key, value)
+	}
+	return nil
+}
+
+// reconfigure applies the hot reloadable settings of cfg to the client.
+func (c *RateLimitedClient) reconfigure(cfg *Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.apiUrl = cfg.ApiUrl
+	c.mirrors = cfg.ApiMirrors
+	c.mirrorTimeout = cfg.MirrorTimeout
+	c.MaxWaiting = int64(cfg.MaxWaiting)
+	c.Adaptive = cfg.AdaptiveRateLimit
+	c.BaseRate = rate.Limit(cfg.RateLimit)
+	c.FloorRate = rate.Limit(cfg.RateFloor)
+	c.LowQuota = cfg.LowQuota
+
+	now := c.clock.Now()
+	c.Ratelimiter.SetLimitAt(now, c.BaseRate)
+	c.Ratelimiter.SetBurstAt(now, cfg.RateBurst)
+}
+
+// reloadConfig rereads CONFIG_FILE and the environment, and applies the
+// settings of the MangaDex client and the cache. Other settings are read
+// by the handlers without synchronization and need a restart.
+func reloadConfig() {
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := loadEnvFile(path); err != nil {
+			fmt.Fprintf(gin.DefaultWriter, "[ERROR]: could not reload configuration: %v\n", err)
+			return
+		}
+	}
+
+	cfg := loadConfig()
+	setMaintenance(cfg.Maintenance)
+	dexClient.reconfigure(&cfg)
+	if dexClient.cache != nil && cfg.CacheTTL > 0 {
+		dexClient.cache.SetLimits(cfg.CacheTTL, cfg.CacheMaxEntries)
+		dexClient.cache.SetJitter(cfg.CacheJitter)
+	}
+
+	fmt.Fprintf(gin.DefaultWriter, "[INFO]: reloaded configuration\n")
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// writeConfigFile writes the lines to a CONFIG_FILE and removes the
+// variables it sets at the end of the test.
+func writeConfigFile(t *testing.T, lines string) string {
+	path := filepath.Join(t.TempDir(), "md-rec.env")
+	if err := os.WriteFile(path, []byte(lines), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for key := range fileEnv {
+			os.Unsetenv(key)
+			delete(fileEnv, key)
+		}
+	})
+	return path
+}
+
+func TestLoadEnvFile(t *testing.T) {
+	t.Setenv("SERVICE_NAME", "from the environment")
+	path := writeConfigFile(t, "# comment\n\nRATE_LIMIT = 2\nSERVICE_NAME=from the file\nSITE_URL=https://md.example.com=1\n")
+
+	if err := loadEnvFile(path); err != nil {
+		t.Fatalf("loadEnvFile = %v", err)
+	}
+	for key, want := range map[string]string{
+		"RATE_LIMIT":   "2",
+		"SERVICE_NAME": "from the environment",
+		"SITE_URL":     "https://md.example.com=1",
+	} {
+		if got := os.Getenv(key); got != want {
+			t.Errorf("%s = %q, want %q", key, got, want)
+		}
+	}
+
+	// Variables from the file are updated by loading it again
+	if err := os.WriteFile(path, []byte("RATE_LIMIT=3\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := loadEnvFile(path); err != nil || os.Getenv("RATE_LIMIT") != "3" {
+		t.Errorf("RATE_LIMIT after reloading = %q (%v), want 3", os.Getenv("RATE_LIMIT"), err)
+	}
+
+	invalid := writeConfigFile(t, "RATE_LIMIT\n")
+	if err := loadEnvFile(invalid); err == nil {
+		t.Error("loadEnvFile of a line without a value = nil, want an error")
+	}
+	if err := loadEnvFile(filepath.Join(t.TempDir(), "missing.env")); err == nil {
+		t.Error("loadEnvFile of a missing file = nil, want an error")
+	}
+}
+
+func TestReloadConfig(t *testing.T) {
+	newTestService(t, func(c *Config) {
+		c.CacheTTL = time.Minute
+	})
+	t.Cleanup(func() { setMaintenance(false) })
+
+	path := writeConfigFile(t, "API_URL=https://api.example.com/\nAPI_MIRRORS=https://mirror.example.com\nRATE_LIMIT=4\nRATE_BURST=8\nMAX_WAITING=7\nMAINTENANCE=true\nCACHE_TTL=2m\nCACHE_MAX_ENTRIES=20\n")
+	t.Setenv("CONFIG_FILE", path)
+	reloadConfig()
+
+	if want := []string{"https://api.example.com", "https://mirror.example.com"}; !reflect.DeepEqual(dexClient.bases(), want) {
+		t.Errorf("bases = %q, want %q", dexClient.bases(), want)
+	}
+	if l, b := dexClient.Ratelimiter.Limit(), dexClient.Ratelimiter.Burst(); l != rate.Limit(4) || b != 8 {
+		t.Errorf("rate = %v burst %d, want 4 burst 8", l, b)
+	}
+	if dexClient.MaxWaiting != 7 {
+		t.Errorf("MaxWaiting = %d, want 7", dexClient.MaxWaiting)
+	}
+	if !inMaintenance() {
+		t.Error("maintenance not enabled")
+	}
+	dexClient.cache.mu.Lock()
+	ttl, max := dexClient.cache.ttl, dexClient.cache.maxEntries
+	dexClient.cache.mu.Unlock()
+	if ttl != 2*time.Minute || max != 20 {
+		t.Errorf("cache ttl = %v with %d entries, want 2m0s with 20", ttl, max)
+	}
+}
+
+func TestReloadConfigRemovedKey(t *testing.T) {
+	newTestService(t, nil)
+	t.Cleanup(func() { setMaintenance(false) })
+
+	path := writeConfigFile(t, "MAX_WAITING=7\nMAINTENANCE=true\n")
+	t.Setenv("CONFIG_FILE", path)
+	reloadConfig()
+	if dexClient.MaxWaiting != 7 || !inMaintenance() {
+		t.Fatalf("MaxWaiting = %d in maintenance %t, want 7 in maintenance", dexClient.MaxWaiting, inMaintenance())
+	}
+
+	// Removing a key restores its default
+	if err := os.WriteFile(path, []byte("MAX_WAITING=7\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	reloadConfig()
+	if _, set := os.LookupEnv("MAINTENANCE"); set {
+		t.Errorf("MAINTENANCE = %q after removing it from the file", os.Getenv("MAINTENANCE"))
+	}
+	if dexClient.MaxWaiting != 7 || inMaintenance() {
+		t.Errorf("MaxWaiting = %d in maintenance %t, want 7 out of maintenance", dexClient.MaxWaiting, inMaintenance())
+	}
+}
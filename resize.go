@@ -0,0 +1,119 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"strconv"
+)
+
+// Bounds of the cover size and quality, requested values are clamped to these
+const (
+	minCoverWidth   = 16
+	maxCoverWidth   = 2048
+	minCoverQuality = 30
+	maxCoverQuality = 95
+
+	// Larger images are not decoded, to bound the memory of a transcode
+	maxDecodePixels = 8000 * 8000
+)
+
+var errImageTooLarge = errors.New("image too large to transcode")
+
+func clamp(v int, min int, max int) int {
+	if v < min {
+		return min
+	}
+	if v > max {
+		return max
+	}
+	return v
+}
+
+// coverOptions returns the width and quality of a proxied cover, the
+// defaults of the config overridden by ?w= and ?q=. Both are 0 if the cover
+// is passed through as is.
+func coverOptions(width string, quality string) (int, int) {
+	w, q := config.CoverWidth, config.CoverQuality
+	if v, err := strconv.Atoi(width); err == nil {
+		w = v
+	}
+	if v, err := strconv.Atoi(quality); err == nil {
+		q = v
+	}
+
+	if w <= 0 && q <= 0 {
+		return 0, 0
+	}
+	if w > 0 {
+		w = clamp(w, minCoverWidth, maxCoverWidth)
+	}
+	if q <= 0 {
+		q = jpeg.DefaultQuality
+	}
+	return w, clamp(q, minCoverQuality, maxCoverQuality)
+}
+
+// transcodeCover scales the image down to at most width pixels wide, if width
+// is not 0, and encodes it as jpeg with the given quality.
+func transcodeCover(body []byte, width int, quality int) ([]byte, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width*cfg.Height > maxDecodePixels {
+		return nil, errImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if width > 0 && img.Bounds().Dx() > width {
+		img = scaleDown(img, width)
+	}
+
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// scaleDown resizes img to width keeping its aspect ratio, averaging the
+// source pixels covered by each target pixel.
+func scaleDown(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+
+			var r, g, bl, a, n uint32
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					pr, pg, pb, pa := img.At(sx, sy).RGBA()
+					r, g, bl, a, n = r+pr, g+pg, bl+pb, a+pa, n+1
+				}
+			}
+
+			i := dst.PixOffset(x, y)
+			dst.Pix[i+0] = uint8(r / n >> 8)
+			dst.Pix[i+1] = uint8(g / n >> 8)
+			dst.Pix[i+2] = uint8(bl / n >> 8)
+			dst.Pix[i+3] = uint8(a / n >> 8)
+		}
+	}
+	return dst
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCover returns a png of the size with a pattern, so the quality of
+// its jpeg changes the size of the file.
+func testCover(t *testing.T, width int, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{uint8(x * 7), uint8(y * 13), uint8(x * y), 0xff})
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestCoverOptions(t *testing.T) {
+	config = loadConfig()
+
+	tests := []struct {
+		defaultWidth, defaultQuality int
+		width, quality               string
+		wantWidth, wantQuality       int
+	}{
+		{0, 0, "", "", 0, 0},
+		{0, 0, "256", "", 256, jpeg.DefaultQuality},
+		{0, 0, "", "50", 0, 50},
+		{512, 80, "", "", 512, 80},
+		{512, 80, "256", "60", 256, 60},
+		{512, 80, "abc", "", 512, 80},
+		{0, 0, "4", "5", minCoverWidth, minCoverQuality},
+		{0, 0, "10000", "100", maxCoverWidth, maxCoverQuality},
+		{512, 0, "0", "", 0, 0},
+	}
+
+	for _, tt := range tests {
+		config.CoverWidth, config.CoverQuality = tt.defaultWidth, tt.defaultQuality
+		w, q := coverOptions(tt.width, tt.quality)
+		if w != tt.wantWidth || q != tt.wantQuality {
+			t.Errorf("coverOptions(%q, %q) with defaults %d, %d = %d, %d, want %d, %d",
+				tt.width, tt.quality, tt.defaultWidth, tt.defaultQuality, w, q, tt.wantWidth, tt.wantQuality)
+		}
+	}
+}
+
+func TestTranscodeCover(t *testing.T) {
+	cover := testCover(t, 400, 600)
+
+	small, err := transcodeCover(cover, 100, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, err := jpeg.Decode(bytes.NewReader(small))
+	if err != nil {
+		t.Fatalf("transcoded cover is not a jpeg: %v", err)
+	}
+	if size := img.Bounds().Size(); size.X != 100 || size.Y != 150 {
+		t.Errorf("transcoded size = %v, want 100x150", size)
+	}
+
+	// Smaller images are not scaled up
+	same, err := transcodeCover(cover, 1000, 80)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg, err := jpeg.DecodeConfig(bytes.NewReader(same)); err != nil || cfg.Width != 400 {
+		t.Errorf("width of a cover smaller than the width = %d (%v), want 400", cfg.Width, err)
+	}
+
+	low, _ := transcodeCover(cover, 0, minCoverQuality)
+	high, _ := transcodeCover(cover, 0, maxCoverQuality)
+	if len(low) >= len(high) {
+		t.Errorf("cover of quality %d is %d bytes, not smaller than the %d bytes of quality %d", minCoverQuality, len(low), len(high), maxCoverQuality)
+	}
+
+	if _, err := transcodeCover([]byte("not an image"), 100, 80); err == nil {
+		t.Error("transcodeCover of a text = nil, want an error")
+	}
+}
+
+func TestProxiedCoverSize(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.CoverWidth = 200
+		c.CoverQuality = 80
+	})
+	const path = "/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.png"
+
+	cover := testCover(t, 400, 600)
+	fixtures.handle("/covers/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.png", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		w.Write(cover)
+	})
+
+	sizes := map[string]int{}
+	for query, width := range map[string]int{"": 200, "?w=100": 100, "?w=100&q=30": 100, "?w=5000": 400} {
+		w := get(r, path+query)
+		if ct := w.Header().Get("Content-Type"); w.Code != http.StatusOK || ct != "image/jpeg" {
+			t.Fatalf("cover%s = %d %s, want a jpeg", query, w.Code, ct)
+		}
+		sizes[query] = w.Body.Len()
+
+		cfg, err := jpeg.DecodeConfig(w.Body)
+		if err != nil || cfg.Width != width {
+			t.Errorf("width of cover%s = %d (%v), want %d", query, cfg.Width, err, width)
+		}
+	}
+	if sizes["?w=100&q=30"] >= sizes["?w=100"] {
+		t.Errorf("cover of quality 30 is %d bytes, not smaller than %d bytes of quality 80", sizes["?w=100&q=30"], sizes["?w=100"])
+	}
+}
+
+func TestCoverQuery(t *testing.T) {
+	tests := []struct {
+		width, quality string
+		want           string
+	}{
+		{"", "", ""},
+		{"200", "", "w=200"},
+		{"", "50", "q=50"},
+		{"200", "50", "q=50&w=200"},
+		{"1", "100", "q=95&w=16"},
+		{"99999", "1", "q=30&w=2048"},
+		{"0", "", "w=0"},
+		{"wide", "high", ""},
+	}
+
+	for _, tt := range tests {
+		if got := coverQuery(tt.width, tt.quality); got != tt.want {
+			t.Errorf("coverQuery(%q, %q) = %q, want %q", tt.width, tt.quality, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedCoverOptions(t *testing.T) {
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.CoverProxy = true
+		c.CacheTTL = time.Minute
+	})
+	const path = "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+	const cover = "http://example.com/cover/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/5c4e7b1a-8d2f-4e3a-9b6c-1d2e3f4a5b6c.jpg"
+
+	tests := []struct {
+		query string
+		image string
+	}{
+		{"", cover},
+		{"?w=200", cover + "?w=200"},
+		{"?w=200&q=50", cover + "?q=50&w=200"},
+		{"?w=300", cover + "?w=300"},
+	}
+
+	etags := map[string]string{}
+	for _, tt := range tests {
+		w := get(r, path+tt.query)
+		assertContains(t, w.Body.String(), `<meta content="`+strings.ReplaceAll(tt.image, "&", "&amp;")+`" property='og:image'>`)
+
+		etag := w.Header().Get("ETag")
+		if other, ok := etags[etag]; ok {
+			t.Errorf("embed%s has the ETag %s of embed%s", tt.query, etag, other)
+		}
+		etags[etag] = tt.query
+	}
+
+	// Clamped options give the same embed as the clamped value
+	if a, b := get(r, path+"?w=99999").Header().Get("ETag"), get(r, path+"?w=2048").Header().Get("ETag"); a != b {
+		t.Errorf("ETags of ?w=99999 and ?w=2048 = %s and %s, want the same", a, b)
+	}
+
+	// The MangaDex response is the same for every size
+	if n := fixtures.count("/manga/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); n != 1 {
+		t.Errorf("manga requested %d times, want 1", n)
+	}
+}
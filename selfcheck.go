@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/gin-gonic/gin"
+)
+
+// selfCheckId is the manga rendered by -selfcheck unless another is given
+const selfCheckId = "a1c7c817-4e59-43b7-9365-09675a149a6f"
+
+// selfCheck renders the embed of mangaId with the embed handler, without
+// starting the server, and returns an error unless it succeeded.
+func selfCheck(tmpl *template.Template, mangaId string) error {
+	w := httptest.NewRecorder()
+	c, engine := gin.CreateTestContext(w)
+	engine.SetHTMLTemplate(tmpl)
+	useMinifiedHTML(engine)
+	c.Request = httptest.NewRequest(http.MethodGet, fmt.Sprintf("/title/%s", mangaId), nil)
+
+	renderEmbed(c, mangaId)
+
+	if w.Code != http.StatusOK {
+		return fmt.Errorf("selfcheck: embed of %s responded with %d", mangaId, w.Code)
+	}
+	if !bytes.Contains(w.Body.Bytes(), []byte(`property="og:title"`)) || bytes.Contains(w.Body.Bytes(), []byte(`content="" property="og:title"`)) {
+		return fmt.Errorf("selfcheck: embed of %s has no title", mangaId)
+	}
+	return nil
+}
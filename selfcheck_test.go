@@ -0,0 +1,37 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestSelfCheck(t *testing.T) {
+	_, fixtures := newTestService(t, nil)
+	fixtures.handle("/manga/00000000-0000-4000-8000-000000000000", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+	fixtures.handle("/manga/00000000-0000-4000-8000-000000000001", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"result": "ok", "data": {"attributes": {"title": {}}, "relationships": []}}`))
+	})
+
+	tests := []struct {
+		id   string
+		want string
+	}{
+		{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", ""},
+		{"0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d", "responded with 404"},
+		{"00000000-0000-4000-8000-000000000000", "responded with 502"},
+		{"00000000-0000-4000-8000-000000000001", "has no title"},
+	}
+
+	for _, tt := range tests {
+		err := selfCheck(templates, tt.id)
+		if tt.want == "" && err != nil {
+			t.Errorf("selfCheck(%s) = %v, want nil", tt.id, err)
+		}
+		if tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)) {
+			t.Errorf("selfCheck(%s) = %v, want an error with %q", tt.id, err, tt.want)
+		}
+	}
+}
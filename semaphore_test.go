@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := newSemaphore(2)
+	if !s.tryAcquire() || !s.tryAcquire() {
+		t.Fatal("could not take both slots")
+	}
+	if s.tryAcquire() {
+		t.Fatal("took a third slot")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.acquire(ctx); err != context.DeadlineExceeded {
+		t.Errorf("acquire of a full semaphore = %v, want context.DeadlineExceeded", err)
+	}
+
+	s.release()
+	if err := s.acquire(context.Background()); err != nil {
+		t.Errorf("acquire after a release = %v", err)
+	}
+
+	unlimited := newSemaphore(0)
+	for i := 0; i < 10; i++ {
+		if !unlimited.tryAcquire() {
+			t.Fatal("semaphore of 0 slots limits")
+		}
+	}
+}
+
+func TestEndpointBudgets(t *testing.T) {
+	_, fixtures := newTestService(t, func(c *Config) {
+		c.AuthorConcurrency = 1
+		c.MangaConcurrency = 1
+	})
+
+	// The authors respond once released, counting how many are requested
+	// at the same time
+	var mu sync.Mutex
+	running, most := 0, 0
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	for _, id := range []string{"8b2c3d4e-5f6a-4b7c-9d8e-0f1a2b3c4d5e", "6c3d4e5f-6a7b-4c8d-ae9f-1a2b3c4d5e6f"} {
+		id := id
+		fixtures.handle("/author/"+id, func(w http.ResponseWriter, r *http.Request) {
+			mu.Lock()
+			running++
+			if running > most {
+				most = running
+			}
+			mu.Unlock()
+
+			started <- struct{}{}
+			<-release
+			body, _ := os.ReadFile(filepath.Join("testdata", "mangadex", "author", id+".json"))
+			w.Write(body)
+
+			mu.Lock()
+			running--
+			mu.Unlock()
+		})
+	}
+
+	manga, err := dexClient.RequestJSON(context.Background(), mangaEndpoint, "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, err := parseMangaResponse(context.Background(), manga, "2b8d4e6f-1a3c-4e5b-8d7f-9a0b1c2d3e4f", nil)
+		done <- err
+	}()
+
+	<-started
+	select {
+	case <-started:
+		t.Error("second author was requested while the first took the only slot")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	// The author budget does not hold up manga requests
+	if _, err := dexClient.RequestJSON(context.Background(), mangaEndpoint, "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"); err != nil {
+		t.Errorf("manga request while the author budget is used up = %v", err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if most != 1 {
+		t.Errorf("%d authors were requested at the same time, want 1", most)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// precompressed are the encodings of precompressed files, in order of preference
+var precompressed = []struct {
+	encoding  string
+	extension string
+}{
+	{"br", ".br"},
+	{"gzip", ".gz"},
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header allows the encoding.
+func acceptsEncoding(header string, encoding string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(fields[0]), encoding) {
+			continue
+		}
+
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(param[2:], 64)
+				return err == nil && q > 0
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// openFile opens the regular file at file.
+func openFile(file string) (*os.File, os.FileInfo, bool) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, nil, false
+	}
+
+	info, err := f.Stat()
+	if err != nil || info.IsDir() {
+		f.Close()
+		return nil, nil, false
+	}
+	return f, info, true
+}
+
+// serveStatic serves a file from the static directory, using a precompressed
+// .br or .gz variant next to it when present and accepted by the client.
+func serveStatic(c *gin.Context) {
+	name := path.Clean("/" + c.Param("filepath"))
+	file := filepath.Join(config.StaticDir, filepath.FromSlash(name))
+
+	c.Header("Vary", "Accept-Encoding")
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		c.Header("Content-Type", contentType)
+	}
+
+	for _, p := range precompressed {
+		if !acceptsEncoding(c.GetHeader("Accept-Encoding"), p.encoding) {
+			continue
+		}
+
+		if f, info, ok := openFile(file + p.extension); ok {
+			defer f.Close()
+			c.Header("Content-Encoding", p.encoding)
+			http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+			return
+		}
+	}
+
+	f, info, ok := openFile(file)
+	if !ok {
+		c.AbortWithStatus(http.StatusNotFound)
+		return
+	}
+	defer f.Close()
+
+	http.ServeContent(c.Writer, c.Request, name, info.ModTime(), f)
+}
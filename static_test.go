@@ -0,0 +1,79 @@
+package main
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestAcceptsEncoding(t *testing.T) {
+	tests := []struct {
+		header, encoding string
+		want             bool
+	}{
+		{"gzip, deflate, br", "br", true},
+		{"gzip, deflate, br", "gzip", true},
+		{"GZIP", "gzip", true},
+		{"br;q=0, gzip", "br", false},
+		{"br;q=0.5", "br", true},
+		{"br;q=x", "br", false},
+		{"deflate", "gzip", false},
+		{"", "br", false},
+	}
+
+	for _, tt := range tests {
+		if got := acceptsEncoding(tt.header, tt.encoding); got != tt.want {
+			t.Errorf("acceptsEncoding(%q, %q) = %t, want %t", tt.header, tt.encoding, got, tt.want)
+		}
+	}
+}
+
+func TestServeStatic(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"app.css":    "body{}",
+		"app.css.br": "brotli",
+		"app.css.gz": "gzip",
+		"app.js":     "plain",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r, _ := newTestService(t, func(c *Config) {
+		c.StaticDir = dir
+	})
+
+	tests := []struct {
+		path, accept string
+		encoding     string
+		body         string
+	}{
+		{"/static/app.css", "gzip, deflate, br", "br", "brotli"},
+		{"/static/app.css", "gzip", "gzip", "gzip"},
+		{"/static/app.css", "br;q=0, gzip", "gzip", "gzip"},
+		{"/static/app.css", "", "", "body{}"},
+		{"/static/app.js", "gzip, br", "", "plain"},
+	}
+
+	for _, tt := range tests {
+		w := get(r, tt.path, "Accept-Encoding", tt.accept)
+		if w.Code != http.StatusOK {
+			t.Errorf("status of %s with %q = %d, want 200", tt.path, tt.accept, w.Code)
+			continue
+		}
+		if enc := w.Header().Get("Content-Encoding"); enc != tt.encoding || w.Body.String() != tt.body {
+			t.Errorf("%s with %q = %q encoded as %q, want %q as %q", tt.path, tt.accept, w.Body, enc, tt.body, tt.encoding)
+		}
+		if ct := w.Header().Get("Content-Type"); ct == "" || ct == "application/x-brotli" {
+			t.Errorf("Content-Type of %s = %q, want the type of the file", tt.path, ct)
+		}
+	}
+
+	for _, path := range []string{"/static/missing.css", "/static/../go.mod", "/static/"} {
+		if w := get(r, path); w.Code != http.StatusNotFound {
+			t.Errorf("status of %s = %d, want 404", path, w.Code)
+		}
+	}
+}
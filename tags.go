@@ -0,0 +1,107 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/valyala/fastjson"
+)
+
+// Tag is a manga tag with the group it belongs to,
+// one of "genre", "theme", "format" or "content".
+type Tag struct {
+	Name  string
+	Group string
+}
+
+// tagGroupOrder is the order in which tag groups are shown when genres
+// are prioritized, unknown groups come last.
+var tagGroupOrder = map[string]int{
+	"genre":   0,
+	"theme":   1,
+	"format":  2,
+	"content": 3,
+}
+
+func tagGroupRank(group string) int {
+	if rank, ok := tagGroupOrder[group]; ok {
+		return rank
+	}
+	return len(tagGroupOrder)
+}
+
+// parseTags reads the english names of the manga tags.
+func parseTags(attr *fastjson.Value) []Tag {
+	var tags []Tag
+	for _, v := range attr.GetArray("tags") {
+		tagAttr := v.Get("attributes")
+		name := string(tagAttr.Get("name").GetStringBytes("en"))
+		if name == "" {
+			continue
+		}
+
+		tags = append(tags, Tag{
+			Name:  name,
+			Group: string(tagAttr.GetStringBytes("group")),
+		})
+	}
+	return tags
+}
+
+// groupTags returns the names of the tags by group, sorted by name.
+// Tags of an unknown or missing group are in "other".
+func groupTags(tags []Tag) map[string][]string {
+	groups := make(map[string][]string)
+	for _, t := range tags {
+		group := t.Group
+		if _, ok := tagGroupOrder[group]; !ok {
+			group = "other"
+		}
+		groups[group] = append(groups[group], t.Name)
+	}
+
+	for _, names := range groups {
+		sort.Strings(names)
+	}
+	return groups
+}
+
+// contentKind returns the first of the kind tags the manga is tagged with,
+// e.g. "Doujinshi" or "Anthology", or "" for normal manga.
+func contentKind(tags []Tag, kinds []string) string {
+	for _, kind := range kinds {
+		for _, t := range tags {
+			if strings.EqualFold(t.Name, kind) {
+				return t.Name
+			}
+		}
+	}
+	return ""
+}
+
+// selectTags orders the tags by name, optionally with genres before themes,
+// and returns the names of at most `max` of them, all if max is 0.
+func selectTags(tags []Tag, genresFirst bool, max int) []string {
+	sorted := make([]Tag, len(tags))
+	copy(sorted, tags)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if genresFirst {
+			ri, rj := tagGroupRank(sorted[i].Group), tagGroupRank(sorted[j].Group)
+			if ri != rj {
+				return ri < rj
+			}
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	if max > 0 && len(sorted) > max {
+		sorted = sorted[:max]
+	}
+
+	names := make([]string, len(sorted))
+	for i, t := range sorted {
+		names[i] = t.Name
+	}
+	return names
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+const tagsFixture = `{
+	"tags": [
+		{"id": "a3c67850-4684-404e-9b7f-c69850ee5da6", "type": "tag", "attributes": {"name": {"en": "Romance"}, "group": "genre"}},
+		{"id": "0234a31e-a729-4e28-9d6a-3f87c4966b9e", "type": "tag", "attributes": {"name": {"en": "Oneshot"}, "group": "format"}},
+		{"id": "caaa44eb-cd40-4177-b930-79d3ef2afe87", "type": "tag", "attributes": {"name": {"en": "School Life"}, "group": "theme"}},
+		{"id": "4d32cc48-9f00-4cca-9b5a-a839f0764984", "type": "tag", "attributes": {"name": {"en": "Comedy"}, "group": "genre"}},
+		{"id": "97893a4c-12af-4dac-b6be-0dffb353568e", "type": "tag", "attributes": {"name": {"en": "Sexual Violence"}, "group": "content"}},
+		{"id": "b9af3a63-f058-46de-a9a0-e0c13906197a", "type": "tag", "attributes": {"name": {"ja": "ドラマ"}, "group": "genre"}},
+		{"id": "0a39b5a1-b235-4886-a747-1d05d216532d", "type": "tag", "attributes": {"name": {"en": "Award Winning"}, "group": "format"}}
+	]
+}`
+
+func TestParseTags(t *testing.T) {
+	tags := parseTags(fastjson.MustParse(tagsFixture))
+
+	// Tags without an english name are left out
+	want := []Tag{
+		{"Romance", "genre"},
+		{"Oneshot", "format"},
+		{"School Life", "theme"},
+		{"Comedy", "genre"},
+		{"Sexual Violence", "content"},
+		{"Award Winning", "format"},
+	}
+	if !reflect.DeepEqual(tags, want) {
+		t.Errorf("parseTags = %v, want %v", tags, want)
+	}
+}
+
+func TestSelectTags(t *testing.T) {
+	tags := parseTags(fastjson.MustParse(tagsFixture))
+
+	tests := []struct {
+		genresFirst bool
+		max         int
+		want        []string
+	}{
+		{false, 0, []string{"Award Winning", "Comedy", "Oneshot", "Romance", "School Life", "Sexual Violence"}},
+		{false, 3, []string{"Award Winning", "Comedy", "Oneshot"}},
+		{true, 0, []string{"Comedy", "Romance", "School Life", "Award Winning", "Oneshot", "Sexual Violence"}},
+		{true, 3, []string{"Comedy", "Romance", "School Life"}},
+		{true, 10, []string{"Comedy", "Romance", "School Life", "Award Winning", "Oneshot", "Sexual Violence"}},
+	}
+
+	for _, tt := range tests {
+		if got := selectTags(tags, tt.genresFirst, tt.max); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("selectTags(genres first %t, max %d) = %v, want %v", tt.genresFirst, tt.max, got, tt.want)
+		}
+	}
+
+	// The order does not depend on the order of the response
+	reversed := make([]Tag, len(tags))
+	for i, tag := range tags {
+		reversed[len(tags)-1-i] = tag
+	}
+	if !reflect.DeepEqual(selectTags(reversed, true, 0), selectTags(tags, true, 0)) {
+		t.Error("selectTags depends on the order of the tags")
+	}
+}
+
+func TestContentKind(t *testing.T) {
+	tags := []Tag{{"Comedy", "genre"}, {"Anthology", "format"}, {"Doujinshi", "format"}}
+
+	tests := []struct {
+		tags  []Tag
+		kinds []string
+		want  string
+	}{
+		{tags, []string{"Doujinshi", "Anthology"}, "Doujinshi"},
+		{tags, []string{"anthology"}, "Anthology"},
+		{tags, nil, ""},
+		{[]Tag{{"Comedy", "genre"}}, []string{"Doujinshi", "Anthology"}, ""},
+	}
+
+	for _, tt := range tests {
+		if got := contentKind(tt.tags, tt.kinds); got != tt.want {
+			t.Errorf("contentKind(%v, %v) = %q, want %q", tt.tags, tt.kinds, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedKind(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.KindTags = []string{"Anthology"}
+	})
+
+	w := get(r, "/title/9f0a1b2c-3d4e-4f5a-8b6c-7d8e9f0a1b2c")
+	assertContains(t, w.Body.String(), `<meta content="Anthology" name="twitter:data2">`)
+
+	w = get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if strings.Contains(w.Body.String(), `name="twitter:label2"`) {
+		t.Error("normal manga has a type label")
+	}
+}
+
+func TestGroupTags(t *testing.T) {
+	tags := append(parseTags(fastjson.MustParse(tagsFixture)), Tag{"Isekai", "setting"}, Tag{"Mecha", ""})
+
+	want := map[string][]string{
+		"genre":   {"Comedy", "Romance"},
+		"theme":   {"School Life"},
+		"format":  {"Award Winning", "Oneshot"},
+		"content": {"Sexual Violence"},
+		"other":   {"Isekai", "Mecha"},
+	}
+	if got := groupTags(tags); !reflect.DeepEqual(got, want) {
+		t.Errorf("groupTags = %v, want %v", got, want)
+	}
+	if got := groupTags(nil); len(got) != 0 {
+		t.Errorf("groupTags(nil) = %v, want none", got)
+	}
+}
+
+func TestEmbedTagGroups(t *testing.T) {
+	newTestService(t, nil)
+	response := `{"data": {"attributes": {"title": {"en": "Tagged"}, ` + strings.TrimPrefix(tagsFixture, "{") + `, "relationships": []}}`
+
+	meta, err := parseMangaResponse(context.Background(), fastjson.MustParse(response), "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range map[string]string{
+		"genres":       "Comedy, Romance",
+		"themes":       "School Life",
+		"formats":      "Award Winning, Oneshot",
+		"content_tags": "Sexual Violence",
+		"other_tags":   "",
+	} {
+		if meta[key] != want {
+			t.Errorf("%s = %q, want %q", key, meta[key], want)
+		}
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+)
+
+// templates are the loaded templates, to look up optional ones
+var templates *template.Template
+
+// requiredTemplates are rendered by the handlers and must be present
+var requiredTemplates = []string{"index.html", "embed.html", "noembed.html", "error.html"}
+
+// loadTemplates parses the templates in dir, failing with a descriptive error
+// instead of the panic of gin's LoadHTMLGlob. Besides the required ones, dir
+// may contain error pages for a single status such as error_404.html.
+func loadTemplates(dir string) (*template.Template, error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("templates: %s is not a directory", dir)
+	}
+
+	tmpl, err := template.ParseGlob(filepath.Join(dir, "*"))
+	if err != nil {
+		return nil, fmt.Errorf("templates: %w", err)
+	}
+
+	for _, name := range requiredTemplates {
+		if tmpl.Lookup(name) == nil {
+			return nil, fmt.Errorf("templates: %s is missing from %s", name, dir)
+		}
+	}
+	return tmpl, nil
+}
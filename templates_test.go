@@ -0,0 +1,62 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadTemplates(t *testing.T) {
+	if _, err := loadTemplates("templates"); err != nil {
+		t.Fatalf("loadTemplates(templates) = %v", err)
+	}
+
+	file := filepath.Join(t.TempDir(), "embed.html")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		files map[string]string
+		want  string
+	}{
+		{"missing directory", nil, "no such file"},
+		{"empty directory", map[string]string{}, "pattern matches no files"},
+		{"missing template", map[string]string{
+			"index.html":   "index",
+			"embed.html":   "embed",
+			"noembed.html": "noembed",
+		}, "error.html is missing"},
+		{"malformed template", map[string]string{
+			"index.html":   "index",
+			"embed.html":   "{{ .og_title ",
+			"noembed.html": "noembed",
+			"error.html":   "error",
+		}, "embed.html"},
+	}
+
+	for _, tt := range tests {
+		dir := filepath.Join(t.TempDir(), "templates")
+		if tt.files != nil {
+			if err := os.Mkdir(dir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for name, content := range tt.files {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		_, err := loadTemplates(dir)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("loadTemplates with a %s = %v, want an error with %q", tt.name, err, tt.want)
+		}
+	}
+
+	if _, err := loadTemplates(file); err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("loadTemplates(%s) = %v, want not a directory", file, err)
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// smallWords are not capitalized by normalizeCase unless they start or end
+// the title, by language of the title.
+var smallWords = map[string]map[string]bool{
+	"en":    wordSet("a an and as at but by for from in into nor of on or over per the to up via vs with"),
+	"fr":    wordSet("à au aux d de des du en et l la le les ou par pour sur un une"),
+	"es":    wordSet("a al con de del e el en la las lo los o para por u un una y"),
+	"pt":    wordSet("a ao as com da das de do dos e em na nas no nos o os para por um uma"),
+	"it":    wordSet("a ad al con da dal de dei del della di e ed il in la le nel per su un una"),
+	"de":    wordSet("am an auf aus bei das dem den der des die ein eine im in mit und vom von zu zum zur"),
+	"ja-ro": wordSet("de e ga ka ni no to wa wo"),
+}
+
+func wordSet(words string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(words) {
+		set[w] = true
+	}
+	return set
+}
+
+// romanNumeral matches the numerals of sequels, e.g. "II" or "XIV"
+var romanNumeral = regexp.MustCompile(`^X{0,3}(IX|IV|V?I{0,3})$`)
+
+// normalizeCase title cases a title that is entirely in upper or lower case,
+// keeping the small words of its language in lower case. Titles in mixed
+// case are left as the authors wrote them, as are titles with letters
+// outside the latin script, such as CJK titles.
+func normalizeCase(title string, lang string) string {
+	if !isLatin(title) || !uniformCase(title) {
+		return title
+	}
+
+	var special unicode.SpecialCase
+	if base := strings.SplitN(lang, "-", 2)[0]; base == "tr" || base == "az" {
+		special = unicode.TurkishCase
+	}
+
+	small := smallWords[lang]
+	if small == nil {
+		small = smallWords[strings.SplitN(lang, "-", 2)[0]]
+	}
+
+	words := strings.Split(title, " ")
+	for i, w := range words {
+		if upper := strings.ToUpper(w); upper != "" && upper != "I" && romanNumeral.MatchString(upper) {
+			words[i] = upper
+			continue
+		}
+
+		lower := strings.ToLowerSpecial(special, w)
+		first := i == 0 || strings.HasSuffix(words[i-1], ":")
+		if !first && i < len(words)-1 && small[lower] {
+			words[i] = lower
+			continue
+		}
+
+		parts := strings.Split(lower, "-")
+		for j, p := range parts {
+			parts[j] = capitalize(p, special)
+		}
+		words[i] = strings.Join(parts, "-")
+	}
+	return strings.Join(words, " ")
+}
+
+// uniformCase reports whether s has cased letters, all in the same case.
+func uniformCase(s string) bool {
+	var upper, lower bool
+	for _, r := range s {
+		upper = upper || unicode.IsUpper(r)
+		lower = lower || unicode.IsLower(r)
+	}
+	return upper != lower
+}
+
+// capitalize upper cases the first letter of s.
+func capitalize(s string, special unicode.SpecialCase) string {
+	for i, r := range s {
+		if unicode.IsLetter(r) {
+			return s[:i] + strings.ToUpperSpecial(special, string(r)) + s[i+len(string(r)):]
+		}
+	}
+	return s
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/valyala/fastjson"
+)
+
+func TestNormalizeCase(t *testing.T) {
+	tests := []struct {
+		title, lang string
+		want        string
+	}{
+		{"ATTACK ON TITAN", "en", "Attack on Titan"},
+		{"the rising of the shield hero", "en", "The Rising of the Shield Hero"},
+		{"DRAGON QUEST: THE ADVENTURE OF DAI", "en", "Dragon Quest: The Adventure of Dai"},
+		{"WHAT WE LIVE FOR", "en", "What We Live For"},
+		{"HIGH-RISE INVASION", "en", "High-Rise Invasion"},
+		{"FINAL FANTASY XIV", "en", "Final Fantasy XIV"},
+		{"I AM A HERO", "en", "I Am a Hero"},
+		{"kaguya-sama wa kokurasetai", "ja-ro", "Kaguya-Sama wa Kokurasetai"},
+		{"ÎLE DE LA CITÉ", "fr", "Île de la Cité"},
+		{"LA CASA DE PAPEL", "es-la", "La Casa de Papel"},
+		{"İSTANBUL VE ISPARTA", "tr", "İstanbul Ve Isparta"},
+		// Mixed case and non-latin titles are left as they are
+		{"Spy x Family", "en", "Spy x Family"},
+		{"ONE PIECE 第1話", "ja", "ONE PIECE 第1話"},
+		{"よつばと！", "ja", "よつばと！"},
+		{"나 혼자만 레벨업", "ko", "나 혼자만 레벨업"},
+		{"2001", "en", "2001"},
+	}
+
+	for _, tt := range tests {
+		if got := normalizeCase(tt.title, tt.lang); got != tt.want {
+			t.Errorf("normalizeCase(%q, %q) = %q, want %q", tt.title, tt.lang, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedTitleCase(t *testing.T) {
+	newTestService(t, nil)
+	response := fastjson.MustParse(`{"data": {"attributes": {"title": {"en": "ATTACK ON TITAN"}}, "relationships": []}}`)
+
+	for _, enabled := range []bool{false, true} {
+		config.TitleCase = enabled
+		meta, err := parseMangaResponse(context.Background(), response, "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := "ATTACK ON TITAN"
+		if enabled {
+			want = "Attack on Titan"
+		}
+		if meta["og_title"] != want {
+			t.Errorf("og_title with TitleCase %t = %q, want %q", enabled, meta["og_title"], want)
+		}
+	}
+}
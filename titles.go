@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/valyala/fastjson"
+)
+
+// isLatin reports whether all letters of s are in the latin script.
+func isLatin(s string) bool {
+	for _, r := range s {
+		if unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			return false
+		}
+	}
+	return true
+}
+
+// sanitizeTitle collapses line breaks and other whitespace of a title to
+// single spaces and removes control characters and bidirectional overrides,
+// which could break plain text output or reorder the text around the title.
+func sanitizeTitle(s string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range s {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+			continue
+		case unicode.IsControl(r), r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069':
+			continue
+		}
+
+		if space && b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		space = false
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// romajiTitle returns the romanized alt title of the manga, preferring the
+// romanization of its original language, e.g. `ja-ro` for japanese manga.
+func romajiTitle(attr *fastjson.Value) string {
+	preferred := string(attr.GetStringBytes("originalLanguage")) + "-ro"
+
+	var first string
+	for _, alt := range attr.GetArray("altTitles") {
+		var match string
+		alt.GetObject().Visit(func(key []byte, v *fastjson.Value) {
+			lang := string(key)
+			t := localizedString(key, v)
+			if t == "" || !strings.HasSuffix(lang, "-ro") || config.hidesAltTitle(lang) {
+				return
+			}
+
+			if lang == preferred {
+				match = t
+			} else if first == "" {
+				first = t
+			}
+		})
+
+		if match != "" {
+			return match
+		}
+	}
+
+	return first
+}
+
+// releaseYear returns the year the manga was first published, if known.
+func releaseYear(attr *fastjson.Value) string {
+	if year := attr.GetInt("year"); year > 0 {
+		return strconv.Itoa(year)
+	}
+	return ""
+}
+
+// updatedAt returns when the manga was last updated on MangaDex, or the zero
+// time if the response does not say.
+func updatedAt(attr *fastjson.Value) time.Time {
+	t, err := time.Parse(time.RFC3339, string(attr.GetStringBytes("updatedAt")))
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// shortId returns the first n characters of the id, leaving out dashes.
+func shortId(id string, n int) string {
+	id = strings.ReplaceAll(id, "-", "")
+	if len(id) > n {
+		return id[:n]
+	}
+	return id
+}
+
+// localizedTitle returns the title or alt title in the first of langs available
+// and its language, or an empty language if there is none.
+func localizedTitle(attr *fastjson.Value, langs []string) (string, string) {
+	titles := []*fastjson.Object{attr.GetObject("title")}
+	for _, alt := range attr.GetArray("altTitles") {
+		titles = append(titles, alt.GetObject())
+	}
+
+	for _, lang := range langs {
+		// A title in exactly the language goes first, so "ja-ro" does not
+		// pick a "ja" alt title listed before the romanized one
+		for i, obj := range titles {
+			if obj == nil || obj.Get(lang) == nil || i > 0 && config.hidesAltTitle(lang) {
+				continue
+			}
+			if t := localizedString([]byte(lang), obj.Get(lang)); t != "" {
+				return t, lang
+			}
+		}
+
+		for i, obj := range titles {
+			if t, l := matchLanguage(obj, []string{lang}); t != "" && (i == 0 || !config.hidesAltTitle(l)) {
+				return t, l
+			}
+		}
+	}
+	return "", ""
+}
+
+// originalTitle returns the title or alt title in the original language of
+// the manga, matching the language exactly so "ja" does not pick "ja-ro".
+func originalTitle(attr *fastjson.Value) string {
+	lang := string(attr.GetStringBytes("originalLanguage"))
+	if lang == "" {
+		return ""
+	}
+
+	if t := string(attr.GetStringBytes("title", lang)); t != "" {
+		return t
+	}
+	if config.hidesAltTitle(lang) {
+		return ""
+	}
+	for _, alt := range attr.GetArray("altTitles") {
+		if t := string(alt.GetStringBytes(lang)); t != "" {
+			return t
+		}
+	}
+	return ""
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/valyala/fastjson"
+)
+
+func TestIsLatin(t *testing.T) {
+	tests := []struct {
+		s    string
+		want bool
+	}{
+		{"Yotsuba&!", true},
+		{"Pokémon Adventures", true},
+		{"Vol. 1: 100%", true},
+		{"よつばと！", false},
+		{"나 혼자만 레벨업", false},
+		{"Yotsuba よつば", false},
+		{"", true},
+	}
+
+	for _, tt := range tests {
+		if got := isLatin(tt.s); got != tt.want {
+			t.Errorf("isLatin(%q) = %t, want %t", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestRomajiTitle(t *testing.T) {
+	config = loadConfig()
+
+	tests := []struct {
+		name string
+		attr string
+		want string
+	}{
+		{
+			name: "original language",
+			attr: `{"originalLanguage": "ja", "altTitles": [{"en": "Yotsuba&!"}, {"zh-ro": "Si Ye Cao"}, {"ja-ro": "Yotsuba to!"}]}`,
+			want: "Yotsuba to!",
+		},
+		{
+			name: "other romanization",
+			attr: `{"originalLanguage": "ja", "altTitles": [{"en": "Yotsuba&!"}, {"ko-ro": "Yotsuba-wa"}]}`,
+			want: "Yotsuba-wa",
+		},
+		{
+			name: "none",
+			attr: `{"originalLanguage": "ja", "altTitles": [{"ja": "よつばと！"}, {"en": "Yotsuba&!"}]}`,
+		},
+	}
+
+	for _, tt := range tests {
+		if got := romajiTitle(fastjson.MustParse(tt.attr)); got != tt.want {
+			t.Errorf("%s: romajiTitle = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPreferRomaji(t *testing.T) {
+	newTestService(t, func(c *Config) {
+		c.PreferRomaji = true
+	})
+
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{
+			name:     "romaji",
+			response: `{"data": {"attributes": {"title": {"ja": "よつばと！"}, "altTitles": [{"ja-ro": "Yotsuba to!"}], "originalLanguage": "ja"}, "relationships": []}}`,
+			want:     "Yotsuba to!",
+		},
+		{
+			name:     "no romaji",
+			response: `{"data": {"attributes": {"title": {"ja": "よつばと！"}, "altTitles": [], "originalLanguage": "ja"}, "relationships": []}}`,
+			want:     "よつばと！",
+		},
+		{
+			name:     "latin",
+			response: `{"data": {"attributes": {"title": {"en": "Yotsuba&!"}, "altTitles": [{"ja-ro": "Yotsuba to!"}], "originalLanguage": "ja"}, "relationships": []}}`,
+			want:     "Yotsuba&!",
+		},
+	}
+
+	for _, tt := range tests {
+		meta, err := parseMangaResponse(context.Background(), fastjson.MustParse(tt.response), "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if meta["title"] != tt.want {
+			t.Errorf("%s: title = %q, want %q", tt.name, meta["title"], tt.want)
+		}
+	}
+}
+
+func TestLocalizedTitle(t *testing.T) {
+	config = loadConfig()
+	attr := fastjson.MustParse(`{
+		"title": {"en": "Yotsuba&!"},
+		"altTitles": [{"ja": "よつばと！"}, {"ja-ro": "Yotsubato!"}, {"pt": "Yotsuba e!"}, {"ru": "Ёцуба!"}]
+	}`)
+
+	tests := []struct {
+		langs  []string
+		hidden []string
+		title  string
+		lang   string
+	}{
+		{[]string{"ja-ro"}, nil, "Yotsubato!", "ja-ro"},
+		{[]string{"ja"}, nil, "よつばと！", "ja"},
+		{[]string{"pt-br"}, nil, "Yotsuba e!", "pt"},
+		{[]string{"de", "ru"}, nil, "Ёцуба!", "ru"},
+		{[]string{"ru", "en"}, []string{"ru"}, "Yotsuba&!", "en"},
+		{[]string{"de"}, nil, "", ""},
+	}
+
+	for _, tt := range tests {
+		config.HiddenTitleLanguages = tt.hidden
+		if title, lang := localizedTitle(attr, tt.langs); title != tt.title || lang != tt.lang {
+			t.Errorf("localizedTitle(%v) hiding %v = %q, %q, want %q, %q", tt.langs, tt.hidden, title, lang, tt.title, tt.lang)
+		}
+	}
+}
+
+func TestOriginalTitle(t *testing.T) {
+	newTestService(t, nil)
+
+	tests := []struct {
+		attr string
+		want string
+	}{
+		{`{"title": {"ja": "よつばと！"}, "altTitles": [], "originalLanguage": "ja"}`, "よつばと！"},
+		{`{"title": {"en": "Yotsuba&!"}, "altTitles": [{"ja-ro": "Yotsubato!"}, {"ja": "よつばと！"}], "originalLanguage": "ja"}`, "よつばと！"},
+		{`{"title": {"en": "Yotsuba&!"}, "altTitles": [{"ja-ro": "Yotsubato!"}], "originalLanguage": "ja"}`, ""},
+		{`{"title": {"en": "Yotsuba&!"}, "altTitles": [{"ja": "よつばと！"}]}`, ""},
+	}
+
+	for _, tt := range tests {
+		if got := originalTitle(fastjson.MustParse(tt.attr)); got != tt.want {
+			t.Errorf("originalTitle(%s) = %q, want %q", tt.attr, got, tt.want)
+		}
+	}
+}
+
+func TestTitlePrimary(t *testing.T) {
+	r, _ := newTestService(t, nil)
+
+	tests := []struct {
+		primary string
+		id      string
+		want    []string
+	}{
+		{"localized", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", []string{
+			`<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`,
+			`<meta content="よつばと！&#10;&#10;`,
+		}},
+		{"original", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", []string{
+			`<meta content="よつばと！ - Azuma Kiyohiko" property="og:title">`,
+			`<meta content="Yotsuba&amp;!&#10;&#10;`,
+		}},
+		{"localized", "5e7a9c1b-3d5f-4b7a-9c1e-3f5a7c9e1b3d", []string{
+			`<meta content="The Tower Climber`,
+			`<meta content="탑을 오르는 자&#10;&#10;`,
+		}},
+		{"original", "5e7a9c1b-3d5f-4b7a-9c1e-3f5a7c9e1b3d", []string{
+			`<meta content="탑을 오르는 자`,
+			`<meta content="The Tower Climber&#10;&#10;`,
+		}},
+	}
+
+	for _, tt := range tests {
+		config.TitlePrimary = tt.primary
+		w := get(r, "/title/"+tt.id)
+		if w.Code != http.StatusOK {
+			t.Fatalf("status with %s titles = %d, want 200", tt.primary, w.Code)
+		}
+		assertContains(t, w.Body.String(), tt.want...)
+	}
+}
+
+func TestHiddenTitleLanguages(t *testing.T) {
+	r, _ := newTestService(t, func(c *Config) {
+		c.HiddenTitleLanguages = []string{"ja", "ja-ro"}
+	})
+	attr := fastjson.MustParse(`{
+		"title": {"en": "Yotsuba&!"},
+		"altTitles": [{"ja": "よつばと！"}, {"ja-ro": "Yotsubato!"}, {"ko-ro": "Yotsuba-wa"}],
+		"originalLanguage": "ja"
+	}`)
+
+	if got := originalTitle(attr); got != "" {
+		t.Errorf("originalTitle = %q, want none", got)
+	}
+	if got := romajiTitle(attr); got != "Yotsuba-wa" {
+		t.Errorf("romajiTitle = %q, want Yotsuba-wa", got)
+	}
+	if got, lang := localizedTitle(attr, []string{"ja", "ja-ro"}); got != "" {
+		t.Errorf("localizedTitle in ja = %q (%s), want none", got, lang)
+	}
+
+	// The main title is shown in any language
+	main := fastjson.MustParse(`{"title": {"ja": "よつばと！"}, "altTitles": [], "originalLanguage": "ja"}`)
+	if got := originalTitle(main); got != "よつばと！" {
+		t.Errorf("originalTitle of the main title = %q, want よつばと！", got)
+	}
+
+	body := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d").Body.String()
+	assertContains(t, body, `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`)
+	for _, hidden := range []string{"よつばと！", "Yotsubato!"} {
+		if strings.Contains(body, hidden) {
+			t.Errorf("embed shows the hidden title %q", hidden)
+		}
+	}
+}
+
+func TestShortId(t *testing.T) {
+	tests := []struct {
+		id   string
+		n    int
+		want string
+	}{
+		{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", 7, "7f3c1b2"},
+		{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", 10, "7f3c1b2e5d"},
+		{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", 40, "7f3c1b2e5d4a4c8e9a6b1e2f3a4b5c6d"},
+	}
+
+	for _, tt := range tests {
+		if got := shortId(tt.id, tt.n); got != tt.want {
+			t.Errorf("shortId(%q, %d) = %q, want %q", tt.id, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestTitleId(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	const path = "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"
+
+	assertContains(t, get(r, path).Body.String(), `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`)
+
+	config.TitleIdLength = 7
+	body := get(r, path).Body.String()
+	assertContains(t, body, `<meta content="Yotsuba&amp;! - Azuma Kiyohiko [7f3c1b2]" property="og:title">`)
+	if m := jsonLDScript.FindStringSubmatch(body); m == nil || strings.Contains(m[1], "7f3c1b2]") {
+		t.Errorf("the id is in the name of the JSON-LD: %v", m)
+	}
+}
+
+func TestSanitizeTitle(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+	}{
+		{"Yotsuba&!", "Yotsuba&!"},
+		{`Say "Hi" <b>Bold</b>`, `Say "Hi" <b>Bold</b>`},
+		{"  Two\r\n\tLines  ", "Two Lines"},
+		{"Tab\tand space", "Tab and space"},
+		{"Bell\a and null\x00", "Bell and null"},
+		{"evil\u202etxt.exe", "eviltxt.exe"},
+		{"\u2066isolated\u2069", "isolated"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeTitle(tt.s); got != tt.want {
+			t.Errorf("sanitizeTitle(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedUnsafeTitle(t *testing.T) {
+	const mangaId = "4c6e8a0b-2d4f-4a6c-8e0b-2d4f6a8c0e1a"
+	const unsafe = `Say \"Hi\"\n<b>Bold</b>\u202e & ?a=b#x`
+
+	fixture, err := os.ReadFile(filepath.Join("testdata", "mangadex", "manga", mangaId+".json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fixture = bytes.Replace(fixture, []byte(`"Untitled Oneshot"`), []byte(`"`+unsafe+`"`), 1)
+
+	tests := []struct {
+		sanitize bool
+		plain    string
+	}{
+		{true, `Say "Hi" <b>Bold</b> & ?a=b#x`},
+		{false, "Say \"Hi\"\n<b>Bold</b>\u202e & ?a=b#x"},
+	}
+
+	for _, tt := range tests {
+		r, fixtures := newTestService(t, func(c *Config) {
+			c.SanitizeTitles = tt.sanitize
+		})
+		fixtures.handle("/manga/"+mangaId, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(fixture)
+		})
+
+		if got := get(r, "/title/"+mangaId, "Accept", "text/plain").Body.String(); got != tt.plain {
+			t.Errorf("plain title with SANITIZE_TITLES=%v = %q, want %q", tt.sanitize, got, tt.plain)
+		}
+
+		w := get(r, "/title/"+mangaId)
+		if got, want := w.Header().Get("Link"), `<https://mangadex.org/title/`+mangaId+`>; rel="canonical"`; got != want {
+			t.Errorf("Link with SANITIZE_TITLES=%v = %q, want %q", tt.sanitize, got, want)
+		}
+		body := w.Body.String()
+		assertContains(t, body, "Say &#34;Hi&#34;", "&lt;b&gt;Bold&lt;/b&gt;")
+		if strings.Contains(body, "<b>") {
+			t.Errorf("embed with SANITIZE_TITLES=%v has an unescaped title:\n%s", tt.sanitize, body)
+		}
+
+		body = get(r, "/title/"+mangaId, "Accept", "application/json").Body.String()
+		assertContains(t, body, `\u003cb\u003eBold\u003c/b\u003e`)
+	}
+}
+
+func TestSetCanonicalEscapesId(t *testing.T) {
+	config = loadConfig()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	canonical := setCanonical(c, `x>; rel="next" <y`)
+	if strings.ContainsAny(canonical, `<>"; `) {
+		t.Errorf("setCanonical = %q, want the id escaped", canonical)
+	}
+	if got, want := w.Header().Get("Link"), "<"+canonical+`>; rel="canonical"`; got != want {
+		t.Errorf("Link = %q, want %q", got, want)
+	}
+}
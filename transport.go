@@ -0,0 +1,32 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// newTransport returns the transport of the outbound requests, through
+// proxyUrl if set and otherwise through the proxy of HTTP_PROXY/HTTPS_PROXY.
+func newTransport(proxyUrl string) (*http.Transport, error) {
+	t := http.DefaultTransport.(*http.Transport).Clone()
+	if proxyUrl == "" {
+		return t, nil
+	}
+
+	u, err := url.Parse(proxyUrl)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy url %q: %w", proxyUrl, err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("invalid proxy url %q: unsupported scheme %q", proxyUrl, u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy url %q: missing host", proxyUrl)
+	}
+
+	t.Proxy = http.ProxyURL(u)
+	return t, nil
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+var mangadexHosts = map[string]bool{
+	"mangadex.org":     true,
+	"www.mangadex.org": true,
+}
+
+// parseMangadexUrl extracts the page kind ("title" or "chapter") and id
+// from a full mangadex.org url.
+func parseMangadexUrl(raw string) (string, string, error) {
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil {
+		return "", "", fmt.Errorf("could not parse url: %w", err)
+	}
+
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", "", errors.New("url is not a http url")
+	}
+
+	if !mangadexHosts[strings.ToLower(u.Hostname())] {
+		return "", "", fmt.Errorf("url is not a mangadex url: %s", u.Host)
+	}
+
+	// Paths are of the form /title/{id}/{slug} or /chapter/{id}/{page}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("url has no id: %s", u.Path)
+	}
+
+	if parts[0] != "title" && parts[0] != "chapter" {
+		return "", "", fmt.Errorf("unsupported mangadex page: %s", parts[0])
+	}
+
+	// The id ends up in the path of the API request
+	if !validId.MatchString(parts[1]) {
+		return "", "", fmt.Errorf("invalid %s id: %q", parts[0], parts[1])
+	}
+	return parts[0], strings.ToLower(parts[1]), nil
+}
+
+// isSelfRedirect reports whether target points to the given host of this service.
+func isSelfRedirect(target string, host string) bool {
+	u, err := url.Parse(target)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	return strings.EqualFold(u.Host, host)
+}
+
+// embedFromUrl renders the embed for a full mangadex url given as ?url=.
+func embedFromUrl(c *gin.Context) {
+	kind, id, err := parseMangadexUrl(c.Query("url"))
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		renderError(c, http.StatusBadRequest, "")
+		return
+	}
+
+	if kind == "chapter" {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+		chapterJSON, err := dexClient.RequestJSON(ctx, chapterEndpoint, id)
+		cancel()
+
+		var notFoundErr *NotFoundError
+		if errors.As(err, &notFoundErr) {
+			renderError(c, http.StatusNotFound, "The chapter could not be found.")
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+			renderError(c, errorStatus(err), "")
+			return
+		}
+
+		id = ""
+		for _, v := range chapterJSON.Get("data").GetArray("relationships") {
+			if string(v.GetStringBytes("type")) == "manga" {
+				id = string(v.GetStringBytes("id"))
+				break
+			}
+		}
+
+		if id == "" {
+			renderError(c, http.StatusBadRequest, "The chapter does not belong to a manga.")
+			return
+		}
+	}
+
+	renderEmbed(c, id)
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseMangadexUrl(t *testing.T) {
+	tests := []struct {
+		url  string
+		kind string
+		id   string
+	}{
+		{"https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/yotsuba", "title", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"},
+		{"https://www.mangadex.org/title/7F3C1B2E-5D4A-4C8E-9A6B-1E2F3A4B5C6D", "title", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"},
+		{" http://MangaDex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/ ", "title", "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"},
+		{"https://mangadex.org/chapter/e1f2a3b4-c5d6-4e7f-8a9b-0c1d2e3f4a5b/3", "chapter", "e1f2a3b4-c5d6-4e7f-8a9b-0c1d2e3f4a5b"},
+	}
+
+	for _, tt := range tests {
+		kind, id, err := parseMangadexUrl(tt.url)
+		if err != nil || kind != tt.kind || id != tt.id {
+			t.Errorf("parseMangadexUrl(%q) = %q, %q, %v, want %q, %q", tt.url, kind, id, err, tt.kind, tt.id)
+		}
+	}
+
+	rejected := []string{
+		"https://example.com/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"https://mangadex.org.example.com/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"ftp://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"https://mangadex.org/user/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d",
+		"https://mangadex.org/title/",
+		"https://mangadex.org/title/..%2F..%2Fauthor%2F9a1b2c3d-4e5f-4a6b-8c7d-9e0f1a2b3c4d",
+		"https://mangadex.org/title/not-an-id",
+		"",
+	}
+	for _, raw := range rejected {
+		if kind, id, err := parseMangadexUrl(raw); err == nil {
+			t.Errorf("parseMangadexUrl(%q) = %q, %q, want an error", raw, kind, id)
+		}
+	}
+}
+
+func TestEmbedFromUrl(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+
+	for _, raw := range []string{
+		"https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d/yotsuba",
+		"https://mangadex.org/chapter/e1f2a3b4-c5d6-4e7f-8a9b-0c1d2e3f4a5b",
+	} {
+		w := get(r, "/embed?url="+url.QueryEscape(raw))
+		if w.Code != http.StatusOK {
+			t.Errorf("status of %s = %d, want 200", raw, w.Code)
+		}
+		assertContains(t, w.Body.String(), `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`)
+	}
+
+	w := get(r, "/embed?url="+url.QueryEscape("https://example.com/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of an external url = %d, want 400", w.Code)
+	}
+
+	w = get(r, "/embed?url="+url.QueryEscape("https://mangadex.org/title/..%2Fauthor"))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid id = %d, want 400", w.Code)
+	}
+	if n := fixtures.count("/author"); n != 0 {
+		t.Errorf("invalid id was requested %d times, want 0", n)
+	}
+}
+
+func TestEmbedFromChapterErrors(t *testing.T) {
+	const chapterId = "0f0f0f0f-0f0f-4f0f-8f0f-0f0f0f0f0f0f"
+	raw := url.QueryEscape("https://mangadex.org/chapter/" + chapterId)
+
+	tests := []struct {
+		name   string
+		status int
+		want   int
+	}{
+		{"missing", http.StatusNotFound, http.StatusNotFound},
+		{"unavailable", http.StatusServiceUnavailable, http.StatusBadGateway},
+	}
+
+	for _, tt := range tests {
+		r, fixtures := newTestService(t, nil)
+		status := tt.status
+		fixtures.handle("/chapter/"+chapterId, func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"result":"error","errors":[]}`))
+		})
+
+		if w := get(r, "/embed?url="+raw); w.Code != tt.want {
+			t.Errorf("status of a %s chapter = %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+
+	// A chapter that takes too long is given up on
+	r, fixtures := newTestService(t, func(c *Config) {
+		c.RequestTimeout = 20 * time.Millisecond
+	})
+	fixtures.handle("/chapter/"+chapterId, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+	})
+	start := time.Now()
+	if w := get(r, "/embed?url="+raw); w.Code != http.StatusGatewayTimeout {
+		t.Errorf("status of a slow chapter = %d, want 504", w.Code)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("slow chapter took %v, want the request timeout", elapsed)
+	}
+}
+
+func TestIsSelfRedirect(t *testing.T) {
+	tests := []struct {
+		target, host string
+		want         bool
+	}{
+		{"https://embed.example.com/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "embed.example.com", true},
+		{"https://EMBED.example.com/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "embed.example.com", true},
+		{"http://localhost:8080/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "localhost:8080", true},
+		{"https://mangadex.org/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "embed.example.com", false},
+		{"http://localhost:8081/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "localhost:8080", false},
+		{"/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", "embed.example.com", false},
+		{"", "embed.example.com", false},
+	}
+
+	for _, tt := range tests {
+		if got := isSelfRedirect(tt.target, tt.host); got != tt.want {
+			t.Errorf("isSelfRedirect(%q, %q) = %t, want %t", tt.target, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestEmbedSelfRedirect(t *testing.T) {
+	// Requests of httptest are sent to example.com
+	r, _ := newTestService(t, func(c *Config) {
+		c.SiteUrl = "http://example.com"
+	})
+
+	w := get(r, "/title/7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	if strings.Contains(w.Body.String(), `http-equiv="Refresh"`) {
+		t.Error("embed redirects to the service itself")
+	}
+	assertContains(t, w.Body.String(), `<meta content="Yotsuba&amp;! - Azuma Kiyohiko" property="og:title">`)
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/valyala/fastjson"
+)
+
+// userEndpoint includes the groups of the user to show their names
+const userEndpoint = "/user/%s?includes[]=scanlation_group"
+
+// userRole turns a MangaDex role such as ROLE_GROUP_LEADER into "Group leader".
+func userRole(role string) string {
+	role = strings.ReplaceAll(strings.ToLower(strings.TrimPrefix(role, "ROLE_")), "_", " ")
+	if role == "" {
+		return ""
+	}
+	return strings.ToUpper(role[:1]) + role[1:]
+}
+
+// userEmbed builds the embed of a user response, listing the roles and
+// scanlation groups of the user.
+func userEmbed(user *fastjson.Value, userId string) gin.H {
+	attr := user.Get("data").Get("attributes")
+	site := fmt.Sprintf("%s/user/%s", config.SiteUrl, userId)
+
+	username := string(attr.GetStringBytes("username"))
+	if config.SanitizeTitles {
+		username = sanitizeTitle(username)
+	}
+
+	var roles []string
+	for _, v := range attr.GetArray("roles") {
+		if role := userRole(string(v.GetStringBytes())); role != "" {
+			roles = append(roles, role)
+		}
+	}
+
+	var groups []string
+	for _, v := range user.Get("data").GetArray("relationships") {
+		if string(v.GetStringBytes("type")) != "scanlation_group" {
+			continue
+		}
+
+		name := string(v.Get("attributes").GetStringBytes("name"))
+		if config.SanitizeTitles {
+			name = sanitizeTitle(name)
+		}
+		if name != "" {
+			groups = append(groups, name)
+		}
+	}
+
+	var lines []string
+	if len(roles) > 0 {
+		lines = append(lines, "Roles: "+strings.Join(roles, ", "))
+	}
+	if len(groups) > 0 {
+		lines = append(lines, "Groups: "+strings.Join(groups, ", "))
+	}
+
+	return gin.H{
+		"og_title":   username,
+		"og_content": strings.Join(lines, "\n"),
+		"og_name":    config.SiteUrl,
+		"redirect":   site,
+		"canonical":  site,
+		"username":   username,
+		"roles":      roles,
+		"groups":     groups,
+	}
+}
+
+// createUserEmbed renders the embed of a MangaDex user profile. Profiles
+// MangaDex does not show get an embed saying so instead of an error.
+func createUserEmbed(c *gin.Context) {
+	userId := strings.ToLower(c.Param("user-id"))
+	if !validId.MatchString(userId) {
+		renderError(c, http.StatusBadRequest, "This is not a valid MangaDex user.")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), config.RequestTimeout)
+	defer cancel()
+
+	user, err := dexClient.RequestJSON(ctx, userEndpoint, userId)
+
+	var upstreamErr *UpstreamError
+	if errors.As(err, &upstreamErr) && (upstreamErr.Code == http.StatusUnauthorized || upstreamErr.Code == http.StatusForbidden) {
+		site := fmt.Sprintf("%s/user/%s", config.SiteUrl, userId)
+		c.HTML(http.StatusOK, "embed.html", gin.H{
+			"og_title": config.PrivateProfileMessage,
+			"og_name":  config.SiteUrl,
+			"redirect": site,
+		})
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(gin.DefaultWriter, "[ERROR]: %v\n", err)
+		renderError(c, errorStatus(err), "")
+		return
+	}
+
+	c.HTML(http.StatusOK, "embed.html", userEmbed(user, userId))
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestUserRole(t *testing.T) {
+	tests := []struct {
+		role string
+		want string
+	}{
+		{"ROLE_GROUP_LEADER", "Group leader"},
+		{"ROLE_MEMBER", "Member"},
+		{"ROLE_POWER_UPLOADER", "Power uploader"},
+		{"ADMIN", "Admin"},
+		{"ROLE_", ""},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := userRole(tt.role); got != tt.want {
+			t.Errorf("userRole(%q) = %q, want %q", tt.role, got, tt.want)
+		}
+	}
+}
+
+func TestUserEmbed(t *testing.T) {
+	r, fixtures := newTestService(t, nil)
+	const userId = "1e2d3c4b-5a6f-4e7d-8c9b-0a1f2e3d4c5b"
+
+	w := get(r, "/user/"+strings.ToUpper(userId))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d, want 200", w.Code)
+	}
+	assertContains(t, w.Body.String(),
+		`<meta content="koiwai_fan" property="og:title">`,
+		"<meta content=\"Roles: Group leader, Member\nGroups: Ayase Scans, Fuuka Translations\" property=\"og:description\">",
+		"https://mangadex.org/user/"+userId,
+	)
+	if n := fixtures.count("/user/" + userId); n != 1 {
+		t.Errorf("user requested %d times, want 1", n)
+	}
+}
+
+func TestUserEmbedErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		want   int
+		body   string
+	}{
+		{"unauthorized", http.StatusUnauthorized, http.StatusOK, `<meta content="This profile is private" property="og:title">`},
+		{"forbidden", http.StatusForbidden, http.StatusOK, `<meta content="This profile is private" property="og:title">`},
+		{"not found", http.StatusNotFound, http.StatusNotFound, ""},
+		{"unavailable", http.StatusServiceUnavailable, http.StatusBadGateway, ""},
+	}
+
+	const userId = "0e0e0e0e-0e0e-4e0e-8e0e-0e0e0e0e0e0e"
+	for _, tt := range tests {
+		r, fixtures := newTestService(t, nil)
+		status := tt.status
+		fixtures.handle("/user/"+userId, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(status)
+			w.Write([]byte(`{"result":"error","errors":[]}`))
+		})
+
+		w := get(r, "/user/"+userId)
+		if w.Code != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, w.Code, tt.want)
+		}
+		if tt.body != "" {
+			assertContains(t, w.Body.String(), tt.body)
+		}
+	}
+
+	r, fixtures := newTestService(t, nil)
+	if w := get(r, "/user/not-an-id"); w.Code != http.StatusBadRequest {
+		t.Errorf("status of an invalid id = %d, want 400", w.Code)
+	}
+	if n := fixtures.count("/user/not-an-id"); n != 0 {
+		t.Errorf("invalid id requested %d times", n)
+	}
+}
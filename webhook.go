@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// embedEvent is posted to the webhook for every rendered embed.
+type embedEvent struct {
+	Id      string    `json:"id"`
+	Status  int       `json:"status"`
+	Latency float64   `json:"latency_ms"`
+	Time    time.Time `json:"time"`
+}
+
+// eventDispatcher posts events to a webhook in the background. Events are
+// queued up to a fixed size and dropped when the queue is full, so a slow
+// webhook never delays an embed. A nil dispatcher drops all events.
+type eventDispatcher struct {
+	url    string
+	client *http.Client
+	queue  chan embedEvent
+}
+
+// webhook dispatches the embed events, nil unless WEBHOOK_URL is set
+var webhook *eventDispatcher
+
+func newEventDispatcher(url string, size int, client *http.Client) *eventDispatcher {
+	d := &eventDispatcher{
+		url:    url,
+		client: client,
+		queue:  make(chan embedEvent, size),
+	}
+	go d.run()
+	return d
+}
+
+// emit queues the event, or drops it if the queue is full.
+func (d *eventDispatcher) emit(e embedEvent) {
+	if d == nil {
+		return
+	}
+
+	select {
+	case d.queue <- e:
+	default:
+		webhookDropped.Add(1)
+	}
+}
+
+// run posts the queued events one at a time.
+func (d *eventDispatcher) run() {
+	for e := range d.queue {
+		if err := d.post(e); err != nil {
+			webhookErrors.Add(1)
+			fmt.Fprintf(gin.DefaultWriter, "[WARNING]: could not post event to webhook: %v\n", err)
+		}
+	}
+}
+
+func (d *eventDispatcher) post(e embedEvent) error {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	resp, err := d.client.Post(d.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook status not ok: %d", resp.StatusCode)
+	}
+	return nil
+}
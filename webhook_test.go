@@ -0,0 +1,99 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestWebhook returns a webhook server sending the events it receives
+// on the channel.
+func newTestWebhook(t *testing.T, status int) (*httptest.Server, chan embedEvent) {
+	events := make(chan embedEvent, 10)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var e embedEvent
+		if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+			t.Errorf("could not decode event: %v", err)
+		}
+		w.WriteHeader(status)
+		events <- e
+	}))
+	t.Cleanup(srv.Close)
+	return srv, events
+}
+
+func TestWebhookEvents(t *testing.T) {
+	r, _ := newTestService(t, nil)
+	srv, events := newTestWebhook(t, http.StatusNoContent)
+	webhook = newEventDispatcher(srv.URL, 10, srv.Client())
+	t.Cleanup(func() { webhook = nil })
+
+	tests := []struct {
+		id     string
+		status int
+	}{
+		{"7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d", http.StatusOK},
+		{"0d0d0d0d-0d0d-4d0d-8d0d-0d0d0d0d0d0d", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		get(r, "/title/"+tt.id)
+
+		select {
+		case e := <-events:
+			if e.Id != tt.id || e.Status != tt.status {
+				t.Errorf("event of %s = {%s %d}, want {%s %d}", tt.id, e.Id, e.Status, tt.id, tt.status)
+			}
+			if e.Latency < 0 || e.Time.IsZero() {
+				t.Errorf("event of %s has latency %v and time %v", tt.id, e.Latency, e.Time)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no event posted for %s", tt.id)
+		}
+	}
+}
+
+func TestWebhookErrors(t *testing.T) {
+	srv, events := newTestWebhook(t, http.StatusInternalServerError)
+	d := newEventDispatcher(srv.URL, 10, srv.Client())
+
+	before := webhookErrors.Value()
+	d.emit(embedEvent{Id: "7f3c1b2e-5d4a-4c8e-9a6b-1e2f3a4b5c6d"})
+	<-events
+
+	deadline := time.Now().Add(time.Second)
+	for webhookErrors.Value() == before && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if got := webhookErrors.Value() - before; got != 1 {
+		t.Errorf("webhook_errors increased by %d, want 1", got)
+	}
+}
+
+func TestWebhookOverflow(t *testing.T) {
+	// Without run nothing takes the events off the queue
+	d := &eventDispatcher{queue: make(chan embedEvent, 2)}
+
+	before := webhookDropped.Value()
+	for i := 0; i < 5; i++ {
+		d.emit(embedEvent{Status: i})
+	}
+
+	if got := webhookDropped.Value() - before; got != 3 {
+		t.Errorf("webhook_dropped increased by %d, want 3", got)
+	}
+	for _, want := range []int{0, 1} {
+		if e := <-d.queue; e.Status != want {
+			t.Errorf("queued event %d, want %d", e.Status, want)
+		}
+	}
+
+	// A nil dispatcher drops events without counting them
+	var none *eventDispatcher
+	none.emit(embedEvent{})
+	if got := webhookDropped.Value() - before; got != 3 {
+		t.Errorf("webhook_dropped increased by %d after a nil emit, want 3", got)
+	}
+}